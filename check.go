@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A checker holds the state of Check.
+type checker struct {
+	tree  *Tree
+	procs map[string]*ProcDefNode
+	chans map[string]*chanUse
+	errs  Diagnostics
+	warns Diagnostics
+}
+
+// A chanUse is what the checker knows of a channel: its declaration, if it
+// is asynchronous, and else its first use, which sets how many values it
+// carries.
+type chanUse struct {
+	decl  *ChanDeclNode
+	first Node
+	arity int
+}
+
+// Check checks the model parsed into treeSet from the input called name
+// before it is run or translated: every process called is defined and
+// called with as many arguments as it has parameters, and every action on
+// a channel carries as many values as the channel's declaration says. The
+// actions on an undeclared channel that carry another number of values
+// than its first use are only warned of: the simulator takes them as long
+// as a send and a receive that meet agree, but the Go backend rejects
+// them. The actions in properties are checked too, and so is that every
+// recursion is guarded by an action. The identifiers are resolved
+// beforehand, which annotates them with what they refer to. Once the
+// arities agree, the types of the values are checked with InferTypes. Check
+// returns the warnings it finds and all the errors as Diagnostics, or nil.
+func Check(treeSet map[string]*Tree, name string) (warnings Diagnostics, err error) {
+	file := treeSet[fileKey(name)]
+	if file == nil {
+		return nil, fmt.Errorf("check: no input named %q", name)
+	}
+	c := &checker{tree: file, procs: make(map[string]*ProcDefNode), chans: make(map[string]*chanUse)}
+	var defs []*ProcDefNode
+	var props []*PropDeclNode
+	for _, n := range file.root.Nodes {
+		switch n := n.(type) {
+		case *ChanDeclNode:
+			c.chans[n.Name.Ident] = &chanUse{decl: n, arity: len(n.Sizes)}
+		case *ProcDefNode:
+			c.procs[n.Name] = n
+			defs = append(defs, n)
+		case *PropDeclNode:
+			props = append(props, n)
+		}
+	}
+	c.resolve(defs, props)
+	for _, def := range defs {
+		Inspect(def.Body, func(n Node) bool {
+			switch n := n.(type) {
+			case *ProcCallNode:
+				c.call(n)
+			case *SendNode:
+				c.action(n, n.Chan, len(n.Args), false)
+			case *AsyncSendNode:
+				c.action(n, n.Chan, len(n.Args), true)
+			case *ReceiveNode:
+				c.action(n, n.Chan, len(n.Vars), false)
+			case *AsyncReceiveNode:
+				c.action(n, n.Chan, len(n.Vars), true)
+			}
+			return true
+		})
+	}
+	c.guarded(defs)
+	for _, p := range props {
+		Inspect(p.Formula, func(n Node) bool {
+			if a, ok := n.(*ActionPatternNode); ok {
+				c.pattern(a)
+			}
+			return true
+		})
+	}
+	if len(c.errs) == 0 {
+		if _, err := InferTypes(file); err != nil {
+			c.errs = err.(Diagnostics)
+		}
+	}
+	c.warns.sort()
+	if len(c.errs) == 0 {
+		return c.warns, nil
+	}
+	c.errs.sort()
+	return c.warns, c.errs
+}
+
+// errorf records an error at the node and returns it, for notes to be added.
+func (c *checker) errorf(n Node, format string, args ...interface{}) *Diagnostic {
+	d := c.tree.diagnostic(SeverityError, n, format, args...)
+	c.errs = append(c.errs, d)
+	return d
+}
+
+// warnf records a warning at the node and returns it.
+func (c *checker) warnf(n Node, format string, args ...interface{}) *Diagnostic {
+	d := c.tree.diagnostic(SeverityWarning, n, format, args...)
+	c.warns = append(c.warns, d)
+	return d
+}
+
+// call checks the invocation of a process.
+func (c *checker) call(n *ProcCallNode) {
+	def := c.procs[n.Name]
+	if def == nil {
+		c.errorf(n, "process %s not defined", n.Name)
+		return
+	}
+	if len(n.Args) != len(def.Params) {
+		c.errorf(n, "wrong number of arguments to process %s: %d, want %d", n.Name, len(n.Args), len(def.Params))
+	}
+}
+
+// action checks an action carrying arity values on the channel ch.
+func (c *checker) action(n Node, ch *IdentifierNode, arity int, async bool) {
+	u := c.chans[ch.Ident]
+	switch {
+	case u == nil && async:
+		c.errorf(n, "asynchronous action on undeclared channel %s", ch)
+		return
+	case u == nil:
+		c.chans[ch.Ident] = &chanUse{first: n, arity: arity}
+		return
+	case u.decl != nil && !async:
+		c.errorf(n, "synchronous action on asynchronous channel %s", ch)
+		return
+	}
+	if arity != u.arity {
+		c.origin(c.mismatch(u)(n, "channel %s carries %s here but %s elsewhere", ch, values(arity), values(u.arity)), ch, u)
+	}
+}
+
+// mismatch returns how to report a use of the channel u with the wrong
+// number of values: as an error if it is declared, else as a warning.
+func (c *checker) mismatch(u *chanUse) func(n Node, format string, args ...interface{}) *Diagnostic {
+	if u.decl != nil {
+		return c.errorf
+	}
+	return c.warnf
+}
+
+// pattern checks the channel of an action pattern of a property.
+func (c *checker) pattern(a *ActionPatternNode) {
+	if a.Op == "" {
+		return
+	}
+	u := c.chans[a.Chan.Ident]
+	if u == nil {
+		c.errorf(a, "no action on channel %s", a.Chan)
+		return
+	}
+	async := a.Op == "!!" || a.Op == "??"
+	switch {
+	case u.decl == nil && async:
+		c.errorf(a, "asynchronous pattern on synchronous channel %s", a.Chan)
+	case u.decl != nil && !async:
+		c.errorf(a, "synchronous pattern on asynchronous channel %s", a.Chan)
+	case a.Args != nil && len(a.Args) != u.arity:
+		c.origin(c.mismatch(u)(a, "channel %s carries %s here but %s elsewhere", a.Chan, values(len(a.Args)), values(u.arity)), a.Chan, u)
+	}
+}
+
+// origin adds to d a note at what set the arity of the channel ch.
+func (c *checker) origin(d *Diagnostic, ch *IdentifierNode, u *chanUse) {
+	if u.decl != nil {
+		d.note(c.tree, u.decl, "channel %s is declared with %s here", ch, values(u.arity))
+		return
+	}
+	d.note(c.tree, u.first, "channel %s first carries %s here", ch, values(u.arity))
+}
+
+// values returns n values in words.
+func values(n int) string {
+	if n == 1 {
+		return "1 value"
+	}
+	return fmt.Sprintf("%d values", n)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestCheckArity(t *testing.T) {
+	testChecks(t, []checkTest{
+		{"matching", "%%\nP(x) = b!(x,x).<P(x)>\nQ = b?(y,z).c!(y+z).<Q>\nR = c?_.<R>\n%%\n", nil},
+		{"undeclared", "%%\nP(x) = b!(x,x).<P(x)>\nQ = b?y.c!y.<Q>\nR = c?w.<R>\n%%\n", []string{
+			"test.tz:3:5: warning: channel b carries 1 value here but 2 values elsewhere",
+			"test.tz:4:7: warning: w is received but not used",
+		}},
+		{"declared", "%%\nchan q [1]\nR = q!!1.q!!(1,2).<R>\n%%\n", []string{
+			"test.tz:3:10: channel q carries 2 values here but 1 value elsewhere",
+		}},
+		{"call", "%%\nP(x) = a!x.<P(x)>\nS = <P(1,2)>\n%%\n", []string{
+			"test.tz:3:6: wrong number of arguments to process P: 2, want 1",
+		}},
+	})
+}
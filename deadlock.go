@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// A Blocked is a process that cannot move in a deadlocked state.
+type Blocked struct {
+	Proc  string // the name of the process
+	At    []int  // its position among the parallel components
+	Pos   string // the position in the model of what it runs
+	Waits []Wait // the actions it waits on; none if all its guards are false
+}
+
+// A Wait is an action a blocked process waits on.
+type Wait struct {
+	Kind ActionKind
+	Chan string
+	Pos  string // the position of the action in the model
+	Why  string // why the action cannot be taken
+}
+
+func (w Wait) String() string {
+	dir := [...]string{ActSend: "send on", ActReceive: "receive on", ActAsyncSend: "send on", ActAsyncReceive: "receive on"}[w.Kind]
+	return fmt.Sprintf("%s %s at %s: %s", dir, w.Chan, w.Pos, w.Why)
+}
+
+// Deadlock returns the processes of s and what each of them waits on. It
+// is meant for a state with no transitions, in which every process that is
+// not done is blocked.
+func (in *Interp) Deadlock(s *State) (blocked []Blocked, err error) {
+	defer catch(&err)
+	in.unfolded = 0
+	ofs := in.offers(s.root, nil, nil)
+	in.leaves(s.root, nil, func(t *term, path []int) {
+		b := Blocked{Proc: t.proc, At: path}
+		b.Pos, _ = in.ev.tree.ErrorContext(t.node)
+		for _, o := range ofs {
+			if !within(o.path, path) {
+				continue
+			}
+			pos, _ := in.ev.tree.ErrorContext(o.node)
+			b.Waits = append(b.Waits, Wait{o.kind, o.ch, pos, in.why(s, o, ofs)})
+		}
+		blocked = append(blocked, b)
+	})
+	return blocked, nil
+}
+
+// within reports whether the position p is path or one inside it, as the
+// offers of the branches of a choice at path are.
+func within(p, path []int) bool {
+	return len(p) >= len(path) && comparePaths(p[:len(path)], path) == 0
+}
+
+// leaves calls f for each term of t that is running a process, with its
+// position among the parallel components.
+func (in *Interp) leaves(t *term, path []int, f func(t *term, path []int)) {
+	if t.par == nil {
+		if !t.done() {
+			f(t, path)
+		}
+		return
+	}
+	for i, c := range t.par {
+		in.leaves(c, appendPath(path, i), f)
+	}
+}
+
+// why tells why the offer o cannot be taken in s, given all the offers ofs.
+func (in *Interp) why(s *State, o *offer, ofs []*offer) string {
+	switch o.kind {
+	case ActAsyncSend:
+		return fmt.Sprintf("buffer full (%d of %d)", len(s.queues[o.ch]), capacity(in.chans[o.ch]))
+	case ActAsyncReceive:
+		return "buffer empty"
+	}
+	want, dir := ActReceive, "receives"
+	if o.kind == ActReceive {
+		want, dir = ActSend, "sends"
+	}
+	for _, p := range ofs {
+		if p.kind == want && p.ch == o.ch && !together(o, p) {
+			return fmt.Sprintf("only the process itself %s on %s", dir, o.ch)
+		}
+	}
+	return fmt.Sprintf("no process %s on %s", dir, o.ch)
+}
+
+// reportDeadlock writes the processes blocked in s to w.
+func (in *Interp) reportDeadlock(w io.Writer, s *State) error {
+	blocked, err := in.Deadlock(s)
+	if err != nil {
+		return err
+	}
+	for _, b := range blocked {
+		who := (&offer{proc: b.Proc, path: b.At}).who()
+		if len(b.Waits) == 0 {
+			fmt.Fprintf(w, "\t%s at %s: no branch enabled\n", who, b.Pos)
+			continue
+		}
+		for i, wait := range b.Waits {
+			if i > 0 {
+				who = "  or"
+			}
+			fmt.Fprintf(w, "\t%s %s\n", who, wait)
+		}
+	}
+	return nil
+}
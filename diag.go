@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Severity tells whether a Diagnostic stops the model from being used.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText writes the severity in words in JSON.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// A Span is a range of the text of a model. Lines and columns count from 1;
+// columns count bytes, and the end is past the last byte of the range.
+type Span struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"endLine"`
+	EndCol  int    `json:"endCol"`
+	pos     Pos    // the offset of the start in the text
+	source  string // the text of the line Line
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Col)
+}
+
+// excerpt writes the first line of the span to w, underlined.
+func (s Span) excerpt(w io.Writer) {
+	if s.source == "" {
+		return
+	}
+	width := s.EndCol - s.Col
+	if s.EndLine > s.Line {
+		width = len(s.source) - (s.Col - 1)
+	}
+	// Tabs are kept under the line so that the caret lines up with it.
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, s.source[:s.Col-1])
+	marks := utf8.RuneCountInString(s.source[s.Col-1 : s.Col-1+width])
+	if marks < 1 {
+		marks = 1
+	}
+	fmt.Fprintf(w, "\t%s\n\t%s^%s\n", s.source, indent, strings.Repeat("~", marks-1))
+}
+
+// A Diagnostic is a problem found in a model, while lexing, parsing or
+// checking it.
+type Diagnostic struct {
+	Span
+	Severity Severity `json:"severity"`
+	Msg      string   `json:"message"`
+	Notes    []*Note  `json:"notes,omitempty"`
+}
+
+// A Note points at another part of the model that explains a Diagnostic.
+type Note struct {
+	Span
+	Msg string `json:"message"`
+}
+
+// Error returns the diagnostic as file:line:col: message.
+func (d *Diagnostic) Error() string {
+	if d.Severity == SeverityWarning {
+		return fmt.Sprintf("%s: warning: %s", d.Span, d.Msg)
+	}
+	return fmt.Sprintf("%s: %s", d.Span, d.Msg)
+}
+
+// Format writes the diagnostic and its notes to w, each with the line of
+// the model it points at.
+func (d *Diagnostic) Format(w io.Writer) {
+	fmt.Fprintln(w, d.Error())
+	d.excerpt(w)
+	for _, n := range d.Notes {
+		fmt.Fprintf(w, "%s: note: %s\n", n.Span, n.Msg)
+		n.excerpt(w)
+	}
+}
+
+// Diagnostics are the problems found in a model, in the order of its text.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := make([]string, len(ds))
+	for i, d := range ds {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// sort sorts the diagnostics in the order of the text.
+func (ds Diagnostics) sort() {
+	sort.SliceStable(ds, func(i, j int) bool { return ds[i].pos < ds[j].pos })
+}
+
+// Format writes the diagnostics to w with the lines they point at.
+func (ds Diagnostics) Format(w io.Writer) {
+	for _, d := range ds {
+		d.Format(w)
+	}
+}
+
+// WriteJSON writes the diagnostics to w as a JSON array, for editors.
+func (ds Diagnostics) WriteJSON(w io.Writer) error {
+	if ds == nil {
+		ds = Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(ds)
+}
+
+// span returns the span of the text of t from pos to end.
+func (t *Tree) span(pos, end Pos) Span {
+	text := t.text
+	if text == "" && t.lex != nil {
+		text = t.lex.input
+	}
+	if int(pos) > len(text) {
+		pos = Pos(len(text))
+	}
+	if end < pos {
+		end = pos
+	} else if int(end) > len(text) {
+		end = Pos(len(text))
+	}
+	s := Span{File: t.name, pos: pos}
+	s.Line, s.Col = lineCol(text, pos)
+	s.EndLine, s.EndCol = lineCol(text, end)
+	start := strings.LastIndex(text[:pos], "\n") + 1
+	stop := strings.IndexByte(text[pos:], '\n')
+	if stop < 0 {
+		stop = len(text) - int(pos)
+	}
+	s.source = strings.TrimRight(text[start:int(pos)+stop], "\r")
+	return s
+}
+
+// lineCol returns the line and column of the offset pos of text.
+func lineCol(text string, pos Pos) (line, col int) {
+	line = 1 + strings.Count(text[:pos], "\n")
+	col = int(pos) - strings.LastIndex(text[:pos], "\n")
+	return line, col
+}
+
+// nodeSpan returns the span of the first token of the node n, a word or a
+// string: the nodes do not record where they end.
+func (t *Tree) nodeSpan(n Node) Span {
+	pos := n.Position()
+	text := t.text
+	if int(pos) > len(text) {
+		return t.span(pos, pos)
+	}
+	if q, err := strconv.QuotedPrefix(text[pos:]); err == nil {
+		return t.span(pos, pos+Pos(len(q)))
+	}
+	end := int(pos)
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		end += size
+	}
+	if end == int(pos) && end < len(text) {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return t.span(pos, Pos(end))
+}
+
+// diagnostic returns a diagnostic at the node n.
+func (t *Tree) diagnostic(severity Severity, n Node, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Span: t.nodeSpan(n), Severity: severity, Msg: fmt.Sprintf(format, args...)}
+}
+
+// note adds to d a note at the node n of the tree t.
+func (d *Diagnostic) note(t *Tree, n Node, format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, &Note{Span: t.nodeSpan(n), Msg: fmt.Sprintf(format, args...)})
+	return d
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around a change in a diff.
+const diffContext = 3
+
+// An edit is a line of a diff: ' ' kept, '-' removed or '+' added.
+type edit struct {
+	op   byte
+	line string
+}
+
+// writeDiff writes to w the difference between the texts a and b, called
+// name, as a unified diff. It writes nothing if they are equal.
+func writeDiff(w io.Writer, name string, a, b []byte) {
+	if bytes.Equal(a, b) {
+		return
+	}
+	x, y := splitLines(a), splitLines(b)
+	edits := diffLines(x, y)
+	fmt.Fprintf(w, "--- %s.orig\n+++ %s\n", name, name)
+	// i and j count the lines of a and b before the edit k.
+	i, j := 0, 0
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		// A hunk runs from diffContext lines before a change to
+		// diffContext lines after the last change closer than twice that.
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for kept := 0; end < len(edits) && kept <= 2*diffContext; end++ {
+			if edits[end].op == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+		}
+		for end > k && edits[end-1].op == ' ' {
+			end--
+		}
+		if end += diffContext; end > len(edits) {
+			end = len(edits)
+		}
+		ai, bj := i-(k-start), j-(k-start)
+		var na, nb int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				na++
+			}
+			if e.op != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", ai+1, na, bj+1, nb)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(w, "%c%s\n", e.op, e.line)
+		}
+		i, j, k = ai+na, bj+nb, end
+	}
+}
+
+// splitLines returns the lines of the text, without their newlines.
+func splitLines(text []byte) []string {
+	s := strings.TrimSuffix(string(text), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns the edits turning the lines x into the lines y, from
+// their longest common subsequence.
+func diffLines(x, y []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i]})
+			i, j = i+1, j+1
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+	return edits
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Equivalence tells which bisimilarity Bisimilar decides.
+type Equivalence int
+
+const (
+	Strong Equivalence = iota // every step, tau included, must be matched
+	Weak                      // tau steps are abstracted away
+)
+
+func (eq Equivalence) String() string {
+	if eq == Weak {
+		return "weakly"
+	}
+	return "strongly"
+}
+
+// A Distinction shows two processes apart: after the actions of Trace,
+// which both can take, the process called Can can take Action but the
+// one called Cannot cannot match it.
+type Distinction struct {
+	Trace       []string
+	Action      string
+	Can, Cannot string
+}
+
+func (d *Distinction) String() string {
+	trace := "at the start"
+	if len(d.Trace) > 0 {
+		trace = "after " + strings.Join(d.Trace, " ")
+	}
+	return fmt.Sprintf("%s, %s can take %s and %s cannot match it", trace, d.Can, d.Action, d.Cannot)
+}
+
+// Bisimilar decides whether the LTSs p and q, of the processes called
+// pname and qname, are bisimilar by partition refinement. If they are not,
+// it returns what tells them apart.
+func Bisimilar(p, q *LTS, pname, qname string, eq Equivalence) (bool, *Distinction) {
+	g := newLTSGraph(p, q)
+	if eq == Weak {
+		g = g.saturate()
+	}
+	history := g.refine()
+	final := history[len(history)-1]
+	if final[0] == final[g.q0] {
+		return true, nil
+	}
+	return false, g.distinguish(history, pname, qname)
+}
+
+// An ltsGraph is the disjoint union of two LTSs, with the labels numbered.
+type ltsGraph struct {
+	labels []string    // the labels by number
+	tau    int         // the number of tau; -1 if no transition is labelled tau
+	out    [][]outEdge // the transitions leaving each state, sorted
+	q0     int         // the initial state of the second LTS; 0 is the first's
+}
+
+// An outEdge is a transition seen from the state it leaves.
+type outEdge struct {
+	label, to int
+}
+
+func newLTSGraph(p, q *LTS) *ltsGraph {
+	g := &ltsGraph{tau: -1, q0: len(p.States)}
+	g.out = make([][]outEdge, len(p.States)+len(q.States))
+	ids := make(map[string]int)
+	for i, l := range []*LTS{p, q} {
+		offset := i * g.q0
+		for _, e := range l.Edges {
+			label := e.Action.String()
+			id, ok := ids[label]
+			if !ok {
+				id = len(g.labels)
+				ids[label] = id
+				g.labels = append(g.labels, label)
+				if label == "tau" {
+					g.tau = id
+				}
+			}
+			g.out[e.From+offset] = append(g.out[e.From+offset], outEdge{id, e.To + offset})
+		}
+	}
+	g.sortOut()
+	return g
+}
+
+// sortOut sorts the transitions leaving each state and drops duplicates.
+func (g *ltsGraph) sortOut() {
+	for s, out := range g.out {
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].label < out[j].label || out[i].label == out[j].label && out[i].to < out[j].to
+		})
+		uniq := out[:0]
+		for i, o := range out {
+			if i == 0 || o != out[i-1] {
+				uniq = append(uniq, o)
+			}
+		}
+		g.out[s] = uniq
+	}
+}
+
+// saturate returns the graph of the weak transitions of g: s =tau=> t if t
+// is reachable from s by zero or more tau steps, and s =a=> t if t is
+// reachable by a step a preceded and followed by any number of tau steps.
+func (g *ltsGraph) saturate() *ltsGraph {
+	tau := g.tau
+	labels := g.labels
+	if tau < 0 {
+		tau = len(labels)
+		labels = append(labels[:len(labels):len(labels)], "tau")
+	}
+	closure := make([][]int, len(g.out))
+	for s := range g.out {
+		seen := map[int]bool{s: true}
+		stack := []int{s}
+		for len(stack) > 0 {
+			t := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			closure[s] = append(closure[s], t)
+			for _, o := range g.out[t] {
+				if o.label == g.tau && !seen[o.to] {
+					seen[o.to] = true
+					stack = append(stack, o.to)
+				}
+			}
+		}
+	}
+	w := &ltsGraph{labels: labels, tau: tau, out: make([][]outEdge, len(g.out)), q0: g.q0}
+	for s := range g.out {
+		for _, t := range closure[s] {
+			w.out[s] = append(w.out[s], outEdge{tau, t})
+			for _, o := range g.out[t] {
+				if o.label == g.tau {
+					continue
+				}
+				for _, u := range closure[o.to] {
+					w.out[s] = append(w.out[s], outEdge{o.label, u})
+				}
+			}
+		}
+	}
+	w.sortOut()
+	return w
+}
+
+// refine computes the coarsest partition of the states in which states of
+// the same block reach the same blocks by the same labels. It returns the
+// partition after each round, starting from the one with a single block; a
+// state's block is numbered in the order the blocks are found.
+func (g *ltsGraph) refine() [][]int {
+	block := make([]int, len(g.out))
+	history := [][]int{block}
+	for n := 1; ; {
+		next := make([]int, len(g.out))
+		ids := make(map[string]int)
+		for s, out := range g.out {
+			var b strings.Builder
+			b.WriteString(strconv.Itoa(block[s]))
+			seen := make(map[outEdge]bool)
+			var sig []outEdge
+			for _, o := range out {
+				o.to = block[o.to]
+				if !seen[o] {
+					seen[o] = true
+					sig = append(sig, o)
+				}
+			}
+			sort.Slice(sig, func(i, j int) bool {
+				return sig[i].label < sig[j].label || sig[i].label == sig[j].label && sig[i].to < sig[j].to
+			})
+			for _, o := range sig {
+				fmt.Fprintf(&b, " %d:%d", o.label, o.to)
+			}
+			id, ok := ids[b.String()]
+			if !ok {
+				id = len(ids)
+				ids[b.String()] = id
+			}
+			next[s] = id
+		}
+		if len(ids) == n {
+			return history
+		}
+		n = len(ids)
+		block = next
+		history = append(history, block)
+	}
+}
+
+// distinguish follows the rounds of the refinement back from the initial
+// states to a step one of them can take and the other cannot match.
+func (g *ltsGraph) distinguish(history [][]int, pname, qname string) *Distinction {
+	level := func(p, q int) int {
+		k := 0
+		for history[k][p] == history[k][q] {
+			k++
+		}
+		return k
+	}
+	d := &Distinction{}
+	p, q := 0, g.q0
+	for {
+		prev := history[level(p, q)-1]
+		a, to, swapped := g.unmatched(p, q, prev)
+		if swapped {
+			p, q = q, p
+		}
+		label := g.labels[a]
+		best := -1
+		for _, o := range g.out[q] {
+			if o.label == a && (best < 0 || level(to, o.to) < level(to, best)) {
+				best = o.to
+			}
+		}
+		if best < 0 {
+			d.Action = label
+			d.Can, d.Cannot = pname, qname
+			if p >= g.q0 {
+				d.Can, d.Cannot = qname, pname
+			}
+			return d
+		}
+		d.Trace = append(d.Trace, label)
+		p, q = to, best
+	}
+}
+
+// unmatched returns a step a to to of p, or of q if swapped is set, that
+// the other state cannot take to a state in the same block of prev.
+func (g *ltsGraph) unmatched(p, q int, prev []int) (a, to int, swapped bool) {
+	for _, side := range [][2]int{{p, q}, {q, p}} {
+	next:
+		for _, o := range g.out[side[0]] {
+			for _, m := range g.out[side[1]] {
+				if m.label == o.label && prev[m.to] == prev[o.to] {
+					continue next
+				}
+			}
+			return o.label, o.to, side[0] != p
+		}
+	}
+	panic("unmatched: the states are not told apart")
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+const equivModel = `%%
+A = a!1.<A>
+A2 = a!1.a!1.<A2>
+Once = a!1.nil
+Hidden = <Tell||Wait>
+Tell = h!0.nil
+Wait = h?x.a!1.nil
+Late = a!1.(b!1.nil + c!1.nil)
+Early = a!1.b!1.nil + a!1.c!1.nil
+AB = a!1.nil + b!2.nil
+BA = b!2.nil + a!1.nil
+%%
+`
+
+func TestBisimilar(t *testing.T) {
+	tests := []struct {
+		p, q         string
+		strong, weak bool
+	}{
+		{"A", "A2", true, true},
+		{"AB", "BA", true, true},
+		{"Once", "Hidden", false, true},
+		{"Late", "Early", false, false},
+		{"A", "Once", false, false},
+	}
+	in := testInterp(t, equivModel)
+	in.Open = true
+	in.Inputs = []Value{0}
+	in.Hide = map[string]bool{"h": true}
+	for _, test := range tests {
+		p, err := in.Explore(test.p, nil, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", test.p, err)
+		}
+		q, err := in.Explore(test.q, nil, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", test.q, err)
+		}
+		for _, eq := range []Equivalence{Strong, Weak} {
+			want := test.strong
+			if eq == Weak {
+				want = test.weak
+			}
+			ok, d := Bisimilar(p, q, test.p, test.q, eq)
+			if ok != want {
+				t.Errorf("%s and %s %s bisimilar: got %v, want %v (%v)", test.p, test.q, eq, ok, want, d)
+			}
+			if !ok && d == nil {
+				t.Errorf("%s and %s not %s bisimilar, with no distinction", test.p, test.q, eq)
+			}
+		}
+	}
+}
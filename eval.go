@@ -0,0 +1,593 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+)
+
+// Value is a value computed by the interpreter: an int64, a bool or a string.
+type Value interface{}
+
+// formatValue formats a value the way it would be written in a model.
+func formatValue(v Value) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatValues formats values as the arguments of an action: a lone value
+// as is and anything else in parentheses.
+func formatValues(vals []Value) string {
+	if len(vals) == 1 {
+		return formatValue(vals[0])
+	}
+	s := "("
+	for i, v := range vals {
+		if i > 0 {
+			s += ","
+		}
+		s += formatValue(v)
+	}
+	return s + ")"
+}
+
+// evalError is the panic value used to unwind a failed evaluation.
+type evalError struct {
+	error
+}
+
+// env is an environment binding variables to values. Extending it leaves
+// the original untouched, so terms may share environments freely.
+type env struct {
+	name string
+	val  Value
+	up   *env
+}
+
+// bind returns e extended with name bound to val.
+func (e *env) bind(name string, val Value) *env {
+	return &env{name: name, val: val, up: e}
+}
+
+// lookup returns the value bound to name.
+func (e *env) lookup(name string) (Value, bool) {
+	for ; e != nil; e = e.up {
+		if e.name == name {
+			return e.val, true
+		}
+	}
+	return nil, false
+}
+
+// evaluator evaluates expressions and calls functions from the @@ blocks
+// and the builtins.
+type evaluator struct {
+	tree     *Tree                    // the tree of the whole input, for error reports
+	helpers  map[string]*ast.FuncDecl // functions of the @@ blocks
+	builtins map[string]interface{}   // Go functions handed to Parse
+}
+
+// errorf reports an evaluation error at the node and stops the evaluation.
+func (ev *evaluator) errorf(n Node, format string, args ...interface{}) {
+	location, _ := ev.tree.ErrorContext(n)
+	panic(evalError{fmt.Errorf("%s: %s", location, fmt.Sprintf(format, args...))})
+}
+
+// eval evaluates the expression n in the environment e.
+func (ev *evaluator) eval(n Node, e *env) Value {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		v, ok := e.lookup(n.Ident)
+		if !ok {
+			ev.errorf(n, "undefined: %s", n.Ident)
+		}
+		return v
+	case *NumberNode:
+		if !n.IsInt {
+			ev.errorf(n, "%s is not an integer", n.Text)
+		}
+		return n.Int64
+	case *StringNode:
+		return n.Text
+	case *BoolNode:
+		return n.True
+	case *UnaryExprNode:
+		v, err := applyUnary(n.Op, ev.eval(n.X, e))
+		if err != nil {
+			ev.errorf(n, "%s", err)
+		}
+		return v
+	case *BinaryExprNode:
+		x := ev.eval(n.X, e)
+		// The logical operators short-circuit.
+		if b, ok := x.(bool); ok && (n.Op == "&&" && !b || n.Op == "||" && b) {
+			return b
+		}
+		v, err := applyBinary(n.Op, x, ev.eval(n.Y, e))
+		if err != nil {
+			ev.errorf(n, "%s", err)
+		}
+		return v
+	case *CallExprNode:
+		args := make([]Value, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = ev.eval(arg, e)
+		}
+		v, err := ev.call(n.Fun.Ident, args)
+		if err != nil {
+			ev.errorf(n, "%s", err)
+		}
+		return v
+	}
+	ev.errorf(n, "unexpected %s in expression", n)
+	return nil
+}
+
+// evalBool evaluates the guard n, which must yield a bool.
+func (ev *evaluator) evalBool(n Node, e *env) bool {
+	b, ok := ev.eval(n, e).(bool)
+	if !ok {
+		ev.errorf(n, "guard %s is not a boolean", n)
+	}
+	return b
+}
+
+// applyUnary applies a unary operator.
+func applyUnary(op string, x Value) (Value, error) {
+	switch x := x.(type) {
+	case int64:
+		switch op {
+		case "-":
+			return -x, nil
+		case "+":
+			return x, nil
+		}
+	case bool:
+		if op == "!" {
+			return !x, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operation: %s%s", op, formatValue(x))
+}
+
+// applyBinary applies a binary operator. The operands must have the same type.
+func applyBinary(op string, x, y Value) (Value, error) {
+	switch x := x.(type) {
+	case int64:
+		if y, ok := y.(int64); ok {
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			case "*":
+				return x * y, nil
+			case "/", "%":
+				if y == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				if op == "/" {
+					return x / y, nil
+				}
+				return x % y, nil
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			case ">=":
+				return x >= y, nil
+			}
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			switch op {
+			case "+":
+				return x + y, nil
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			case ">=":
+				return x >= y, nil
+			}
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch op {
+			case "&&":
+				return x && y, nil
+			case "||":
+				return x || y, nil
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid operation: %s %s %s", formatValue(x), op, formatValue(y))
+}
+
+// call calls the function name, a @@ helper or a builtin, with args.
+func (ev *evaluator) call(name string, args []Value) (Value, error) {
+	if fn := ev.helpers[name]; fn != nil {
+		return ev.callHelper(fn, args)
+	}
+	if fn := ev.builtins[name]; fn != nil {
+		return callBuiltin(name, fn, args)
+	}
+	return nil, fmt.Errorf("function %q not defined", name)
+}
+
+// callBuiltin calls the Go function fn through reflection and returns its
+// first result.
+func callBuiltin(name string, fn interface{}, args []Value) (Value, error) {
+	f := reflect.ValueOf(fn)
+	typ := f.Type()
+	if typ.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a function", name)
+	}
+	if len(args) < typ.NumIn()-1 || !typ.IsVariadic() && len(args) != typ.NumIn() {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %d", name, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var want reflect.Type
+		if typ.IsVariadic() && i >= typ.NumIn()-1 {
+			want = typ.In(typ.NumIn() - 1).Elem()
+		} else {
+			want = typ.In(i)
+		}
+		v := reflect.ValueOf(arg)
+		switch {
+		case v.Type().AssignableTo(want):
+		case v.Type().ConvertibleTo(want) && v.Kind() == want.Kind():
+			v = v.Convert(want)
+		case v.Kind() == reflect.Int64 && want.Kind() == reflect.Int:
+			v = v.Convert(want)
+		default:
+			return nil, fmt.Errorf("wrong type for argument %d of %s: %s", i+1, name, v.Type())
+		}
+		in[i] = v
+	}
+	out := f.Call(in)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s returns no value", name)
+	}
+	return fromGo(out[0].Interface())
+}
+
+// fromGo converts a Go value to a Value.
+func fromGo(v interface{}) (Value, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64, bool, string:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+}
+
+// goFrame is a scope of variables while interpreting a @@ helper.
+type goFrame struct {
+	vars map[string]Value
+	up   *goFrame
+}
+
+// find returns the frame declaring name, or nil.
+func (f *goFrame) find(name string) *goFrame {
+	for ; f != nil; f = f.up {
+		if _, ok := f.vars[name]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// goReturn is the panic value carrying the result of a helper out of its body.
+type goReturn struct {
+	val Value
+}
+
+// callHelper interprets the function fn from a @@ block. It understands the
+// plain Go such helpers are written in: ints, bools and strings, variables,
+// assignments, if, for, return and calls to other helpers and builtins.
+func (ev *evaluator) callHelper(fn *ast.FuncDecl, args []Value) (result Value, err error) {
+	var params []string
+	for _, field := range fn.Type.Params.List {
+		for _, name := range field.Names {
+			params = append(params, name.Name)
+		}
+	}
+	if len(args) != len(params) {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %d, want %d", fn.Name.Name, len(args), len(params))
+	}
+	frame := &goFrame{vars: make(map[string]Value)}
+	for i, name := range params {
+		frame.vars[name] = args[i]
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case goReturn:
+				result = e.val
+			case evalError:
+				err = fmt.Errorf("in %s: %v", fn.Name.Name, e.error)
+			default:
+				panic(e)
+			}
+		}
+	}()
+	ev.goBlock(fn.Body.List, frame)
+	return nil, fmt.Errorf("%s returned no value", fn.Name.Name)
+}
+
+// goFail stops interpreting a helper.
+func goFail(format string, args ...interface{}) {
+	panic(evalError{fmt.Errorf(format, args...)})
+}
+
+// goBlock interprets a list of statements in a new scope.
+func (ev *evaluator) goBlock(list []ast.Stmt, up *goFrame) {
+	frame := &goFrame{vars: make(map[string]Value), up: up}
+	for _, stmt := range list {
+		ev.goStmt(stmt, frame)
+	}
+}
+
+// goLoopControl is the panic value of break and continue.
+type goLoopControl token.Token
+
+// goStmt interprets a statement.
+func (ev *evaluator) goStmt(stmt ast.Stmt, frame *goFrame) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		ev.goBlock(s.List, frame)
+	case *ast.ReturnStmt:
+		if len(s.Results) != 1 {
+			goFail("return must have exactly one value")
+		}
+		panic(goReturn{ev.goExpr(s.Results[0], frame)})
+	case *ast.IfStmt:
+		scope := &goFrame{vars: make(map[string]Value), up: frame}
+		if s.Init != nil {
+			ev.goStmt(s.Init, scope)
+		}
+		if ev.goBool(s.Cond, scope) {
+			ev.goBlock(s.Body.List, scope)
+		} else if s.Else != nil {
+			ev.goStmt(s.Else, scope)
+		}
+	case *ast.ForStmt:
+		ev.goFor(s, frame)
+	case *ast.AssignStmt:
+		ev.goAssign(s, frame)
+	case *ast.IncDecStmt:
+		name := goIdent(s.X)
+		f := frame.find(name)
+		if f == nil {
+			goFail("undefined: %s", name)
+		}
+		op := "+"
+		if s.Tok == token.DEC {
+			op = "-"
+		}
+		v, err := applyBinary(op, f.vars[name], int64(1))
+		if err != nil {
+			goFail("%s", err)
+		}
+		f.vars[name] = v
+	case *ast.DeclStmt:
+		gen, ok := s.Decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			goFail("unsupported declaration")
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				var v Value = int64(0)
+				if i < len(vs.Values) {
+					v = ev.goExpr(vs.Values[i], frame)
+				} else if id, ok := vs.Type.(*ast.Ident); ok {
+					v = goZero(id.Name)
+				}
+				frame.vars[name.Name] = v
+			}
+		}
+	case *ast.ExprStmt:
+		ev.goExpr(s.X, frame)
+	case *ast.BranchStmt:
+		if s.Label != nil || s.Tok != token.BREAK && s.Tok != token.CONTINUE {
+			goFail("unsupported %s", s.Tok)
+		}
+		panic(goLoopControl(s.Tok))
+	case *ast.EmptyStmt:
+	default:
+		goFail("unsupported statement %T", stmt)
+	}
+}
+
+// goFor interprets a for statement.
+func (ev *evaluator) goFor(s *ast.ForStmt, frame *goFrame) {
+	scope := &goFrame{vars: make(map[string]Value), up: frame}
+	if s.Init != nil {
+		ev.goStmt(s.Init, scope)
+	}
+	for s.Cond == nil || ev.goBool(s.Cond, scope) {
+		if ev.goLoopBody(s.Body.List, scope) == token.BREAK {
+			return
+		}
+		if s.Post != nil {
+			ev.goStmt(s.Post, scope)
+		}
+	}
+}
+
+// goLoopBody interprets one iteration of a loop and reports whether it
+// ended with break or continue.
+func (ev *evaluator) goLoopBody(list []ast.Stmt, frame *goFrame) (tok token.Token) {
+	defer func() {
+		if e := recover(); e != nil {
+			ctl, ok := e.(goLoopControl)
+			if !ok {
+				panic(e)
+			}
+			tok = token.Token(ctl)
+		}
+	}()
+	ev.goBlock(list, frame)
+	return token.ILLEGAL
+}
+
+// goAssign interprets an assignment or short variable declaration.
+func (ev *evaluator) goAssign(s *ast.AssignStmt, frame *goFrame) {
+	if len(s.Lhs) != len(s.Rhs) {
+		goFail("unsupported assignment")
+	}
+	vals := make([]Value, len(s.Rhs))
+	for i, rhs := range s.Rhs {
+		vals[i] = ev.goExpr(rhs, frame)
+	}
+	for i, lhs := range s.Lhs {
+		name := goIdent(lhs)
+		if name == "_" {
+			continue
+		}
+		if s.Tok == token.DEFINE {
+			frame.vars[name] = vals[i]
+			continue
+		}
+		f := frame.find(name)
+		if f == nil {
+			goFail("undefined: %s", name)
+		}
+		v := vals[i]
+		if s.Tok != token.ASSIGN {
+			// x op= y
+			op := s.Tok.String()
+			var err error
+			if v, err = applyBinary(op[:len(op)-1], f.vars[name], v); err != nil {
+				goFail("%s", err)
+			}
+		}
+		f.vars[name] = v
+	}
+}
+
+// goIdent returns the name of the variable x, which must be an identifier.
+func goIdent(x ast.Expr) string {
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		goFail("unsupported assignment to %T", x)
+	}
+	return id.Name
+}
+
+// goZero returns the zero value of the named type.
+func goZero(typ string) Value {
+	switch typ {
+	case "bool":
+		return false
+	case "string":
+		return ""
+	}
+	return int64(0)
+}
+
+// goBool evaluates the condition x, which must yield a bool.
+func (ev *evaluator) goBool(x ast.Expr, frame *goFrame) bool {
+	b, ok := ev.goExpr(x, frame).(bool)
+	if !ok {
+		goFail("condition is not a boolean")
+	}
+	return b
+}
+
+// goExpr evaluates a Go expression.
+func (ev *evaluator) goExpr(x ast.Expr, frame *goFrame) Value {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return ev.goExpr(x.X, frame)
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.INT:
+			v, err := strconv.ParseInt(x.Value, 0, 64)
+			if err != nil {
+				goFail("%s", err)
+			}
+			return v
+		case token.CHAR:
+			r, _, _, err := strconv.UnquoteChar(x.Value[1:len(x.Value)-1], '\'')
+			if err != nil {
+				goFail("%s", err)
+			}
+			return int64(r)
+		case token.STRING:
+			s, err := strconv.Unquote(x.Value)
+			if err != nil {
+				goFail("%s", err)
+			}
+			return s
+		}
+		goFail("unsupported literal %s", x.Value)
+	case *ast.Ident:
+		if f := frame.find(x.Name); f != nil {
+			return f.vars[x.Name]
+		}
+		switch x.Name {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		goFail("undefined: %s", x.Name)
+	case *ast.UnaryExpr:
+		v, err := applyUnary(x.Op.String(), ev.goExpr(x.X, frame))
+		if err != nil {
+			goFail("%s", err)
+		}
+		return v
+	case *ast.BinaryExpr:
+		l := ev.goExpr(x.X, frame)
+		if b, ok := l.(bool); ok && (x.Op == token.LAND && !b || x.Op == token.LOR && b) {
+			return b
+		}
+		v, err := applyBinary(x.Op.String(), l, ev.goExpr(x.Y, frame))
+		if err != nil {
+			goFail("%s", err)
+		}
+		return v
+	case *ast.CallExpr:
+		fun, ok := x.Fun.(*ast.Ident)
+		if !ok {
+			goFail("unsupported call")
+		}
+		args := make([]Value, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = ev.goExpr(arg, frame)
+		}
+		v, err := ev.call(fun.Name, args)
+		if err != nil {
+			goFail("%s", err)
+		}
+		return v
+	}
+	goFail("unsupported expression %T", x)
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An LTS is the labelled transition system of a process: its reachable
+// states and the transitions between them.
+type LTS struct {
+	States    []*State // the states; the first is the initial state
+	Keys      []string // the canonical form of each state
+	Edges     []Edge   // the transitions in the order they were found
+	Deadlocks []int    // the states that are blocked without being done
+	Truncated bool     // whether states were left out to respect the bound
+
+	in *Interp // the interpreter that explored the states
+}
+
+// An Edge is a transition of an LTS.
+type Edge struct {
+	From, To int
+	Action   Action
+}
+
+// Explore builds the LTS of the process root called with args, visiting
+// states breadth first. It stops adding states once it has maxStates of
+// them; transitions to the states left out are dropped and the LTS is
+// marked as truncated.
+func (in *Interp) Explore(root string, args []Value, maxStates int) (*LTS, error) {
+	s, err := in.Start(root, args)
+	if err != nil {
+		return nil, err
+	}
+	l := &LTS{in: in}
+	seen := make(map[string]int)
+	add := func(s *State) (int, bool) {
+		key := in.StateKey(s)
+		if i, ok := seen[key]; ok {
+			return i, true
+		}
+		if len(l.States) >= maxStates {
+			l.Truncated = true
+			return -1, false
+		}
+		seen[key] = len(l.States)
+		l.States = append(l.States, s)
+		l.Keys = append(l.Keys, key)
+		return len(l.States) - 1, true
+	}
+	add(s)
+	for i := 0; i < len(l.States); i++ {
+		s := l.States[i]
+		trs, err := in.Transitions(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(trs) == 0 && !s.Done() {
+			l.Deadlocks = append(l.Deadlocks, i)
+		}
+		for _, tr := range trs {
+			next, err := in.Apply(s, tr)
+			if err != nil {
+				return nil, err
+			}
+			if j, ok := add(next); ok {
+				l.Edges = append(l.Edges, Edge{i, j, tr.Action})
+			}
+		}
+	}
+	return l, nil
+}
+
+// WriteSummary writes the counts of states, transitions and deadlocks of l.
+func (l *LTS) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "%d states, %d transitions, %d deadlocks\n", len(l.States), len(l.Edges), len(l.Deadlocks))
+	if l.Truncated {
+		fmt.Fprintf(w, "the state bound was reached: the LTS is incomplete\n")
+	}
+}
+
+// numberNodes numbers the process nodes of the file in lexical order, so
+// that the keys of states are the same from run to run.
+func (in *Interp) numberNodes(file *Tree) {
+	in.ids = make(map[Node]int)
+	in.free = make(map[Node][]string)
+	for _, n := range file.root.Nodes {
+		def, ok := n.(*ProcDefNode)
+		if !ok {
+			continue
+		}
+		Inspect(def.Body, func(n Node) bool {
+			if n != nil {
+				in.ids[n] = len(in.ids)
+			}
+			return true
+		})
+	}
+}
+
+// freeVars returns the sorted variables the process node n reads from its
+// environment.
+func (in *Interp) freeVars(n Node) []string {
+	if vars, ok := in.free[n]; ok {
+		return vars
+	}
+	set := make(map[string]bool)
+	addFreeVars(n, set)
+	vars := make([]string, 0, len(set))
+	for v := range set {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	in.free[n] = vars
+	return vars
+}
+
+// addFreeVars adds the variables n reads from its environment to set.
+func addFreeVars(n Node, set map[string]bool) {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		set[n.Ident] = true
+	case *SendNode:
+		for _, arg := range n.Args {
+			addFreeVars(arg, set)
+		}
+	case *AsyncSendNode:
+		for _, arg := range n.Args {
+			addFreeVars(arg, set)
+		}
+	case *ReceiveNode, *AsyncReceiveNode:
+	case *PrefixNode:
+		addFreeVars(n.Left, set)
+		right := make(map[string]bool)
+		addFreeVars(n.Right, right)
+		for _, v := range receivedVars(n.Left) {
+			delete(right, v.Ident)
+		}
+		for v := range right {
+			set[v] = true
+		}
+	case *CallExprNode:
+		for _, arg := range n.Args {
+			addFreeVars(arg, set)
+		}
+	case *ProcCallNode:
+		for _, arg := range n.Args {
+			addFreeVars(arg, set)
+		}
+	default:
+		for _, c := range children(n) {
+			addFreeVars(c, set)
+		}
+	}
+}
+
+// receivedVars returns the variables bound by the action n.
+func receivedVars(n Node) []*IdentifierNode {
+	switch n := n.(type) {
+	case *ReceiveNode:
+		return n.Vars
+	case *AsyncReceiveNode:
+		return n.Vars
+	}
+	return nil
+}
+
+// StateKey returns the canonical form of s: two states have the same key
+// when they run the same process nodes with the same values of the
+// variables those nodes read and their channels hold the same messages.
+func (in *Interp) StateKey(s *State) string {
+	var b strings.Builder
+	in.termKey(&b, s.root)
+	var chans []string
+	for ch := range s.queues {
+		chans = append(chans, ch)
+	}
+	sort.Strings(chans)
+	for _, ch := range chans {
+		b.WriteString(" " + ch + "=[")
+		for i, msg := range s.queues[ch] {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(formatValues(msg))
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+func (in *Interp) termKey(b *strings.Builder, t *term) {
+	if t.par != nil {
+		b.WriteString("<")
+		for i, c := range t.par {
+			if i > 0 {
+				b.WriteString("|")
+			}
+			in.termKey(b, c)
+		}
+		b.WriteString(">")
+	} else if t.node == nil {
+		b.WriteString("0")
+	} else {
+		in.nodeKey(b, t.node, t.env)
+	}
+	for c := t.next; c != nil; c = c.next {
+		b.WriteString(";")
+		in.nodeKey(b, c.node, c.env)
+	}
+}
+
+// StateString returns the process term s runs, in which the variables its
+// processes read are replaced by their values, as in <a!1.b?x.nil||c!2.nil>,
+// followed by the messages its channels hold.
+func (in *Interp) StateString(s *State) string {
+	var b strings.Builder
+	in.termString(&b, s.root)
+	var chans []string
+	for ch := range s.queues {
+		chans = append(chans, ch)
+	}
+	sort.Strings(chans)
+	for _, ch := range chans {
+		b.WriteString(" " + ch + "=[")
+		for i, msg := range s.queues[ch] {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(formatValues(msg))
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+func (in *Interp) termString(b *strings.Builder, t *term) {
+	if t.par != nil {
+		b.WriteString("<")
+		for i, c := range t.par {
+			if i > 0 {
+				b.WriteString("||")
+			}
+			in.termString(b, c)
+		}
+		b.WriteString(">")
+	} else if t.node == nil {
+		b.WriteString("nil")
+	} else {
+		b.WriteString(in.bound(t.node, t.env).String())
+	}
+	for c := t.next; c != nil; c = c.next {
+		b.WriteString(".")
+		b.WriteString(in.bound(c.node, c.env).String())
+	}
+}
+
+// bound returns a copy of the process node n in which the free variables
+// are replaced by their values in e.
+func (in *Interp) bound(n Node, e *env) Node {
+	vals := make(map[string]string)
+	for _, v := range in.freeVars(n) {
+		if val, ok := e.lookup(v); ok {
+			vals[v] = formatValue(val)
+		}
+	}
+	if len(vals) == 0 {
+		return n
+	}
+	n = n.Copy()
+	bindValues(n, vals)
+	return n
+}
+
+// bindValues replaces the variables n reads by their values in vals. It
+// follows addFreeVars, so the variables received in n are left alone.
+func bindValues(n Node, vals map[string]string) {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		if val, ok := vals[n.Ident]; ok {
+			n.Ident = val
+		}
+	case *SendNode:
+		for _, arg := range n.Args {
+			bindValues(arg, vals)
+		}
+	case *AsyncSendNode:
+		for _, arg := range n.Args {
+			bindValues(arg, vals)
+		}
+	case *ReceiveNode, *AsyncReceiveNode:
+	case *PrefixNode:
+		bindValues(n.Left, vals)
+		right := vals
+		if vars := receivedVars(n.Left); len(vars) > 0 {
+			right = make(map[string]string)
+			for v, val := range vals {
+				right[v] = val
+			}
+			for _, v := range vars {
+				delete(right, v.Ident)
+			}
+		}
+		bindValues(n.Right, right)
+	case *CallExprNode:
+		for _, arg := range n.Args {
+			bindValues(arg, vals)
+		}
+	case *ProcCallNode:
+		for _, arg := range n.Args {
+			bindValues(arg, vals)
+		}
+	default:
+		for _, c := range children(n) {
+			bindValues(c, vals)
+		}
+	}
+}
+
+// nodeKey writes the number of the process node n followed by the values
+// of its free variables in e.
+func (in *Interp) nodeKey(b *strings.Builder, n Node, e *env) {
+	b.WriteString("#" + strconv.Itoa(in.ids[n]))
+	vars := in.freeVars(n)
+	if len(vars) == 0 {
+		return
+	}
+	b.WriteString("{")
+	for i, v := range vars {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		val, _ := e.lookup(v)
+		b.WriteString(v + "=" + formatValue(val))
+	}
+	b.WriteString("}")
+}
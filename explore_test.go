@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+const exploreModel = `%%
+Srv = req?x.<W(x)>.<Srv>
+W(x) = resp!x.nil
+Srv2 = req?x.resp!x.<Srv2>
+Cli = req!1.resp?y.<Cli>
+Sys = <Srv||Cli>
+Sys2 = <Srv2||Cli>
+%%
+`
+
+func TestExplore(t *testing.T) {
+	in := testInterp(t, exploreModel)
+
+	// A server that spawns a worker for each request and calls itself
+	// again comes back to the state it started from.
+	l, err := in.Explore("Sys", nil, 100)
+	if err != nil {
+		t.Fatalf("Sys: %v", err)
+	}
+	if l.Truncated || len(l.States) != 2 || len(l.Edges) != 2 {
+		t.Errorf("Sys has %d states and %d transitions (truncated %v), want 2 and 2", len(l.States), len(l.Edges), l.Truncated)
+	}
+	want := []string{
+		"<req?x.<W(x)>.<Srv>||req!1.resp?y.<Cli>>",
+		"<resp!1.nil||req?x.<W(x)>.<Srv>||resp?y.<Cli>>",
+	}
+	for i, s := range l.States {
+		if i < len(want) && in.StateString(s) != want[i] {
+			t.Errorf("state %d of Sys is %s, want %s", i, in.StateString(s), want[i])
+		}
+	}
+	l2, err := in.Explore("Sys2", nil, 100)
+	if err != nil {
+		t.Fatalf("Sys2: %v", err)
+	}
+	if ok, d := Bisimilar(l, l2, "Sys", "Sys2", Strong); !ok {
+		t.Errorf("Sys and Sys2 are not bisimilar: %v", d)
+	}
+}
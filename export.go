@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteAut writes l in the Aldebaran format read by CADP and mCRL2: a
+// header des (initial state, transitions, states) followed by a line
+// (from, "label", to) for each transition. Synchronizations are labelled
+// tau.
+func (l *LTS) WriteAut(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "des (0, %d, %d)\n", len(l.Edges), len(l.States))
+	for _, e := range l.Edges {
+		fmt.Fprintf(b, "(%d, %s, %d)\n", e.From, autLabel(e.Action), e.To)
+	}
+	return b.Flush()
+}
+
+// autLabel quotes the label of a transition. The Aldebaran format has no
+// escapes, so double quotes inside the label become single quotes.
+func autLabel(a Action) string {
+	return `"` + strings.Replace(a.String(), `"`, "'", -1) + `"`
+}
+
+// WriteDot writes l as a Graphviz graph called name. The initial state is
+// drawn with a double circle, the deadlocks are filled in red and each
+// state shows the process term it runs as a tooltip.
+func (l *LTS) WriteDot(w io.Writer, name string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "digraph %s {\n", strconv.Quote(name))
+	fmt.Fprintf(b, "\tnode [shape=circle];\n")
+	deadlock := make(map[int]bool)
+	for _, i := range l.Deadlocks {
+		deadlock[i] = true
+	}
+	for i, s := range l.States {
+		attrs := []string{"tooltip=" + strconv.Quote(l.in.StateString(s))}
+		if i == 0 {
+			attrs = append(attrs, "shape=doublecircle")
+		}
+		if deadlock[i] {
+			attrs = append(attrs, "style=filled", "fillcolor=red")
+		}
+		fmt.Fprintf(b, "\t%d [%s];\n", i, strings.Join(attrs, ", "))
+	}
+	for _, e := range l.Edges {
+		fmt.Fprintf(b, "\t%d -> %d [label=%s];\n", e.From, e.To, strconv.Quote(e.Action.String()))
+	}
+	fmt.Fprintf(b, "}\n")
+	return b.Flush()
+}
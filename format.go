@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Format returns the model in src, called name, in the canonical layout:
+// one declaration per line, the branches of a choice one per line with the
+// + under the = of the definition, the bodies of if and else indented by
+// four spaces past the line that opens them, and no space around . and ||.
+// Comments are kept next to what they lead or trail: a declaration, a
+// branch, the body of an if or else, or, inside a line, an action or call.
+// The text outside the process sections, with the @@ blocks, is kept as
+// it is but for line endings and trailing blanks. Formatting the result
+// again changes nothing.
+func Format(name string, src []byte, funcs ...map[string]interface{}) ([]byte, error) {
+	text := string(src)
+	t := NewTree(name)
+	t.Mode = ParseComments
+	if _, err := t.Parse(text, "%%", "%%", make(map[string]*Tree), funcs...); err != nil {
+		return nil, err
+	}
+	f := &formatter{src: text}
+	last := 0
+	for _, sec := range t.sections {
+		f.verbatim(text[last:sec.pos])
+		var decls []Node
+		for _, n := range t.root.Nodes {
+			if n.Position() >= sec.pos && n.Position() < sec.end {
+				decls = append(decls, n)
+			}
+		}
+		var comments []*CommentNode
+		for _, c := range t.comments {
+			if c.Pos >= sec.pos && c.Pos < sec.end {
+				comments = append(comments, c)
+			}
+		}
+		var tokens []item
+		for _, tok := range t.tokens {
+			if tok.pos >= sec.pos && tok.pos < sec.end {
+				tokens = append(tokens, tok)
+			}
+		}
+		f.section(decls, comments, tokens)
+		last = int(sec.end)
+	}
+	f.verbatim(text[last:])
+	out := bytes.TrimRight(f.out.Bytes(), "\n")
+	return append(out, '\n'), nil
+}
+
+// A formatter holds the state of Format.
+type formatter struct {
+	src      string
+	out      bytes.Buffer
+	col      int    // the column of the next byte written, from 0
+	pending  int    // the indentation owed to the line begun, if col is 0
+	indent   int    // the column the lines of the current process start at
+	tokens   []item // the tokens of the section, but spaces and comments
+	leading  map[Node][]*CommentNode
+	trailing map[Node][]*CommentNode
+	before   map[Node][]*CommentNode      // the comments leading an action or call within its line
+	after    map[Node][]*CommentNode      // those trailing one
+	opening  map[*ListNode][]*CommentNode // those ending the line of the { of a body
+	closing  map[*ListNode][]*CommentNode // those on lines of their own before the } of a body
+	end      []*CommentNode               // the comments after the last declaration
+}
+
+// verbatim writes text outside the process sections, with its line endings
+// made \n and the blanks at the end of its lines dropped.
+func (f *formatter) verbatim(text string) {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			f.out.WriteByte('\n')
+		}
+		f.out.WriteString(strings.TrimRight(line, " \t\r"))
+	}
+	f.col = 0
+}
+
+// write writes s, which holds no newline, after the indentation owed.
+func (f *formatter) write(s string) {
+	if f.col == 0 && f.pending > 0 {
+		f.out.WriteString(strings.Repeat(" ", f.pending))
+		f.col = f.pending
+	}
+	f.pending = 0
+	f.out.WriteString(s)
+	f.col += len(s)
+}
+
+// newline ends the line; the next one starts at the column col.
+func (f *formatter) newline(col int) {
+	f.out.WriteByte('\n')
+	f.col = 0
+	f.pending = col
+}
+
+// section writes a process section of the declarations decls, with the
+// comments and the tokens in it.
+func (f *formatter) section(decls []Node, comments []*CommentNode, tokens []item) {
+	f.tokens = tokens
+	f.attach(decls, comments)
+	f.write("%%")
+	f.newline(0)
+	for i, n := range decls {
+		f.comments(f.leading[n], 0, i > 0)
+		if i > 0 && f.blankBefore(n.Position()) && len(f.leading[n]) == 0 {
+			f.newline(0)
+		}
+		f.declaration(n)
+		f.trail(n)
+		f.newline(0)
+	}
+	f.comments(f.end, 0, len(decls) > 0)
+	f.write("%%")
+}
+
+// An anchor is a declaration, a branch or the body of an if or else: a
+// process that starts a line of the output and ends one.
+type anchor struct {
+	n          Node
+	pos, limit Pos  // the extent of n, from its first token up to what follows it
+	lead       Node // what the comments leading n lead
+}
+
+// attach ties each comment to the anchor it leads, if it starts its line,
+// or else to the one whose line it ends. An anchor trails the comments of
+// one line at most, at the end of its last line; those after that lead
+// what follows it. The comments leading the first branch of a definition
+// lead the definition. A comment on a line of its own before the } of a
+// body stays at the end of the body and one ending the line of a { stays
+// after it. A comment inside a line leads the action or call that starts
+// right after it, or else trails the one before it.
+func (f *formatter) attach(decls []Node, comments []*CommentNode) {
+	var anchors []anchor
+	var atoms []Node                  // the actions, calls and ifs, in lexical order
+	opens := make(map[Pos]*ListNode)  // the body opened by each {
+	closes := make(map[Pos]*ListNode) // the body closed by each }
+	var add func(n, lead Node, pos, limit Pos)
+	var inside func(n, lead Node, limit Pos)
+	// branches adds the anchors of the branches of c, which ends at limit.
+	// The last branch of a choice in parentheses does not end its line:
+	// the comments after it trail what holds the choice.
+	branches := func(c *ChoiceNode, lead Node, limit Pos, paren bool) {
+		for i, b := range c.Branches {
+			pos, end := f.start(b), limit
+			if i > 0 {
+				pos, lead = f.plus(b), b
+			}
+			if i+1 < len(c.Branches) {
+				end = f.plus(c.Branches[i+1])
+			} else if paren {
+				end = pos + 1
+			}
+			add(b, lead, pos, end)
+		}
+	}
+	body := func(list *ListNode, limit Pos) {
+		if f.token(list.Pos).typ == itemLeftCurlyBracket {
+			limit = f.closer(list.Pos+1, itemLeftCurlyBracket, itemRightCurlyBracket)
+			opens[list.Pos], closes[limit] = list, list
+		}
+		n := list.Nodes[0]
+		if c, ok := n.(*ChoiceNode); ok {
+			branches(c, c.Branches[0], limit, false)
+		} else {
+			add(n, n, f.start(n), limit)
+		}
+	}
+	// inside adds the anchors and atoms within the process n, part of the
+	// anchor lead that ends at limit.
+	inside = func(n, lead Node, limit Pos) {
+		switch n := n.(type) {
+		case *PrefixNode:
+			inside(n.Left, lead, limit)
+			inside(n.Right, lead, limit)
+		case *ChoiceNode:
+			if !multiline(n) {
+				for _, b := range n.Branches {
+					inside(b, lead, limit)
+				}
+				return
+			}
+			last := n.Branches[len(n.Branches)-1]
+			branches(n, lead, f.closer(f.start(last), itemLeftParen, itemRightParen), true)
+		case *IfNode:
+			atoms = append(atoms, n)
+			body(n.List, limit)
+			if n.ElseList != nil {
+				body(n.ElseList, limit)
+			}
+		default:
+			atoms = append(atoms, n)
+		}
+	}
+	add = func(n, lead Node, pos, limit Pos) {
+		anchors = append(anchors, anchor{n, pos, limit, lead})
+		inside(n, n, limit)
+	}
+	for i, n := range decls {
+		limit := f.tokens[len(f.tokens)-1].pos // the closing %%
+		if i+1 < len(decls) {
+			limit = decls[i+1].Position()
+		}
+		anchors = append(anchors, anchor{n, n.Position(), limit, n})
+		def, ok := n.(*ProcDefNode)
+		if !ok {
+			continue
+		}
+		// The branches of a choice end the lines of the definition.
+		if choice, ok := def.Body.(*ChoiceNode); ok {
+			branches(choice, n, limit, false)
+		} else {
+			inside(def.Body, n, limit)
+		}
+	}
+	// within returns the innermost anchor whose extent holds pos, or nil.
+	within := func(pos Pos) *anchor {
+		var in *anchor
+		for i := range anchors {
+			a := &anchors[i]
+			if a.pos <= pos && pos < a.limit && (in == nil || a.pos > in.pos || a.limit < in.limit) {
+				in = a
+			}
+		}
+		return in
+	}
+	f.leading = make(map[Node][]*CommentNode)
+	f.trailing = make(map[Node][]*CommentNode)
+	f.before = make(map[Node][]*CommentNode)
+	f.after = make(map[Node][]*CommentNode)
+	f.opening = make(map[*ListNode][]*CommentNode)
+	f.closing = make(map[*ListNode][]*CommentNode)
+	f.end = nil
+	// lead ties c to what leads the anchor of the token next after it.
+	lead := func(c *CommentNode, next *item) {
+		var a *anchor
+		if next != nil {
+			a = within(next.pos)
+		}
+		if a == nil {
+			f.end = append(f.end, c)
+			return
+		}
+		f.leading[a.lead] = append(f.leading[a.lead], c)
+	}
+	// trail ties c to the anchor of the token prev before it, if it can
+	// trail it, or else to what follows.
+	trail := func(c *CommentNode, prev, next *item) {
+		if a := within(prev.pos); a != nil && f.trails(a.n, c) {
+			f.trailing[a.n] = append(f.trailing[a.n], c)
+			return
+		}
+		lead(c, next)
+	}
+	for _, c := range comments {
+		prev, next := f.around(c)
+		lineStart := strings.LastIndex(f.src[:c.Pos], "\n") + 1
+		switch {
+		case prev == nil || strings.TrimSpace(f.src[lineStart:c.Pos]) == "":
+			if next != nil && closes[next.pos] != nil {
+				f.closing[closes[next.pos]] = append(f.closing[closes[next.pos]], c)
+			} else {
+				lead(c, next)
+			}
+		case next == nil || strings.Contains(f.src[c.Pos:next.pos], "\n"):
+			if list := opens[prev.pos]; list != nil {
+				f.opening[list] = append(f.opening[list], c)
+			} else {
+				trail(c, prev, next)
+			}
+		default:
+			k := sort.Search(len(atoms), func(i int) bool { return f.start(atoms[i]) >= next.pos })
+			a := within(prev.pos)
+			switch {
+			case k < len(atoms) && f.start(atoms[k]) == next.pos:
+				f.before[atoms[k]] = append(f.before[atoms[k]], c)
+			case k > 0 && a != nil && f.start(atoms[k-1]) >= a.pos:
+				// The condition of an if ends the line of its {.
+				if n, ok := atoms[k-1].(*IfNode); ok {
+					f.opening[n.List] = append(f.opening[n.List], c)
+				} else {
+					f.after[atoms[k-1]] = append(f.after[atoms[k-1]], c)
+				}
+			default:
+				trail(c, prev, next)
+			}
+		}
+	}
+}
+
+// around returns the tokens right before and after the comment c, or nil.
+func (f *formatter) around(c *CommentNode) (prev, next *item) {
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].pos > c.Pos })
+	if i > 0 {
+		prev = &f.tokens[i-1]
+	}
+	if i < len(f.tokens) {
+		next = &f.tokens[i]
+	}
+	return prev, next
+}
+
+// token returns the token at pos, or the zero item.
+func (f *formatter) token(pos Pos) item {
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].pos >= pos })
+	if i < len(f.tokens) && f.tokens[i].pos == pos {
+		return f.tokens[i]
+	}
+	return item{}
+}
+
+// start returns the position of the first token of the process n, the
+// parentheses opening before it included.
+func (f *formatter) start(n Node) Pos {
+	pos := leftmost(n)
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].pos >= pos })
+	for ; i > 0 && f.tokens[i-1].typ == itemLeftParen; i-- {
+		pos = f.tokens[i-1].pos
+	}
+	return pos
+}
+
+// plus returns the position of the + before the branch b of a choice.
+func (f *formatter) plus(b Node) Pos {
+	pos := leftmost(b)
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].pos >= pos })
+	for i--; i > 0 && f.tokens[i].typ != itemPlus; i-- {
+	}
+	return f.tokens[i].pos
+}
+
+// closer returns the position of the first token of type right from pos
+// on that closes what is open at pos, the tokens of type left opening
+// more. If there is none, it returns the end of the input.
+func (f *formatter) closer(pos Pos, left, right itemType) Pos {
+	depth := 0
+	for _, tok := range f.tokens {
+		switch {
+		case tok.pos < pos:
+		case tok.typ == left:
+			depth++
+		case tok.typ == right && depth == 0:
+			return tok.pos
+		case tok.typ == right:
+			depth--
+		}
+	}
+	return Pos(len(f.src))
+}
+
+// leftmost returns the position of the first token of the process n.
+func leftmost(n Node) Pos {
+	pos := n.Position()
+	Inspect(n, func(n Node) bool {
+		if n != nil && n.Position() < pos {
+			pos = n.Position()
+		}
+		return true
+	})
+	return pos
+}
+
+// blankBefore reports whether a blank line comes before the text at pos.
+func (f *formatter) blankBefore(pos Pos) bool {
+	lines := 0
+	for i := int(pos) - 1; i >= 0; i-- {
+		switch f.src[i] {
+		case '\n':
+			lines++
+		case ' ', '\t', '\r':
+		default:
+			return lines > 1
+		}
+	}
+	return false
+}
+
+// comments writes the comments cs on lines of their own at the column col,
+// each after a blank line if one comes before it and blank allows.
+func (f *formatter) comments(cs []*CommentNode, col int, blank bool) {
+	for _, c := range cs {
+		if blank && f.blankBefore(c.Pos) {
+			f.newline(0)
+		}
+		f.pending = col
+		f.comment(c)
+		f.newline(col)
+		blank = true
+	}
+}
+
+// comment writes the text of the comment c.
+func (f *formatter) comment(c *CommentNode) {
+	lines := strings.Split(strings.Replace(c.Text, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			f.out.WriteByte('\n')
+			f.col = 0
+			f.out.WriteString(line)
+			f.col = len(line)
+			continue
+		}
+		f.write(strings.TrimRight(line, " \t\r"))
+	}
+}
+
+// trails reports whether the comment c can trail n: whether n trails no
+// comment yet, or only block comments on the line of c.
+func (f *formatter) trails(n Node, c *CommentNode) bool {
+	cs := f.trailing[n]
+	if len(cs) == 0 {
+		return true
+	}
+	last := cs[len(cs)-1]
+	return strings.HasPrefix(last.Text, leftComment) && !strings.Contains(f.src[last.Pos:c.Pos], "\n")
+}
+
+// trail writes the comments trailing n, if any, at the end of the line.
+func (f *formatter) trail(n Node) {
+	for _, c := range f.trailing[n] {
+		f.write(" ")
+		f.comment(c)
+	}
+}
+
+// declaration writes the declaration n.
+func (f *formatter) declaration(n Node) {
+	def, ok := n.(*ProcDefNode)
+	if !ok {
+		f.write(n.String())
+		return
+	}
+	if len(def.Params) == 0 {
+		f.write(def.Name + " = ")
+	} else {
+		f.write(fmt.Sprintf("%s(%s) = ", def.Name, joinNodes(identNodes(def.Params), ",")))
+	}
+	f.indent = f.col
+	choice, ok := def.Body.(*ChoiceNode)
+	if !ok {
+		f.proc(def.Body)
+		return
+	}
+	for i, b := range choice.Branches {
+		if i > 0 {
+			f.trail(choice.Branches[i-1])
+			f.newline(f.indent - 2)
+			f.comments(f.leading[b], f.indent-2, false)
+			f.write("+ ")
+		}
+		f.proc(b)
+	}
+	f.trail(choice.Branches[len(choice.Branches)-1])
+}
+
+// choice writes the branches of the choice c, which starts at the current
+// column, one per line with the + two columns to the left.
+func (f *formatter) choice(c *ChoiceNode) {
+	outer := f.indent
+	f.indent = f.col
+	if f.col == 0 {
+		f.indent = f.pending
+	}
+	for i, b := range c.Branches {
+		if i > 0 {
+			f.trail(c.Branches[i-1])
+			f.newline(f.indent - 2)
+			f.comments(f.leading[b], f.indent-2, false)
+			f.write("+ ")
+		}
+		f.proc(b)
+	}
+	f.trail(c.Branches[len(c.Branches)-1])
+	f.indent = outer
+}
+
+// proc writes the process n.
+func (f *formatter) proc(n Node) {
+	for _, c := range f.before[n] {
+		f.comment(c)
+		f.write(" ")
+	}
+	switch n := n.(type) {
+	case *PrefixNode:
+		f.proc(n.Left)
+		f.write(".")
+		f.proc(n.Right)
+	case *ChoiceNode:
+		// A choice within a process keeps its parentheses and its line,
+		// unless an if spreads it over several.
+		f.write("(")
+		if multiline(n) {
+			f.choice(n)
+		} else {
+			for i, b := range n.Branches {
+				if i > 0 {
+					f.write(" + ")
+				}
+				f.proc(b)
+			}
+		}
+		f.write(")")
+	case *IfNode:
+		f.write(fmt.Sprintf("if %s {", n.Cond))
+		f.block(n.List)
+		// An else if keeps its line, with no braces of its own.
+		for n.ElseList != nil {
+			elif, ok := n.ElseList.Nodes[0].(*IfNode)
+			if !ok || f.token(n.ElseList.Pos).typ == itemLeftCurlyBracket {
+				f.write("} else {")
+				f.block(n.ElseList)
+				break
+			}
+			f.write("} else ")
+			for _, c := range f.before[elif] {
+				f.comment(c)
+				f.write(" ")
+			}
+			n = elif
+			f.write(fmt.Sprintf("if %s {", n.Cond))
+			f.block(n.List)
+		}
+		f.write("}")
+	default:
+		f.write(n.String())
+		for _, c := range f.after[n] {
+			f.write(" ")
+			f.comment(c)
+		}
+	}
+}
+
+// block writes the processes of the body of an if or else, four columns in
+// from the current process, with their comments, and starts the line of
+// the closing brace.
+func (f *formatter) block(list *ListNode) {
+	for _, c := range f.opening[list] {
+		f.write(" ")
+		f.comment(c)
+	}
+	outer := f.indent
+	f.indent = outer + 4
+	for _, n := range list.Nodes {
+		f.newline(f.indent)
+		if c, ok := n.(*ChoiceNode); ok {
+			f.comments(f.leading[c.Branches[0]], f.indent, false)
+			f.choice(c)
+		} else {
+			f.comments(f.leading[n], f.indent, false)
+			f.proc(n)
+			f.trail(n)
+		}
+	}
+	if cs := f.closing[list]; len(cs) > 0 {
+		f.newline(f.indent)
+		f.comments(cs, f.indent, false)
+		f.pending = outer
+	} else {
+		f.newline(outer)
+	}
+	f.indent = outer
+}
+
+// multiline reports whether the process n takes several lines: whether an
+// if is part of it.
+func multiline(n Node) bool {
+	found := false
+	Inspect(n, func(n Node) bool {
+		if _, ok := n.(*IfNode); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
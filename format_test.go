@@ -0,0 +1,151 @@
+package main
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"layout", `@@
+func f() int { return 1 }
+@@
+%%
+P = a!f() . <P>   // p
+
+
+Q=b?x.<Q>
+%%
+`, `@@
+func f() int { return 1 }
+@@
+%%
+P = a!f().<P> // p
+
+Q = b?x.<Q>
+%%
+`},
+		{"bodies", `%%
+P(x) = if x > 0 { a!1.<P(x)> + b!1.<P(x)> } else { c!1 }
+Q = a?y.(if y > 1 { b!1 + c!1 } else {b!2}).<Q>
+%%
+`, `%%
+P(x) = if x > 0 {
+           a!1.<P(x)>
+         + b!1.<P(x)>
+       } else {
+           c!1
+       }
+Q = a?y.if y > 1 {
+        b!1
+      + c!1
+    } else {
+        b!2
+    }.<Q>
+%%
+`},
+		{"comments", `%%
+// lead P
+P(x) = if x > 0 {
+           // lead send
+           a!x /* mid */ . <P(x-1)> // trail send
+       } else {
+           // lead else
+           b!x
+       } // trail if
+Q = a?y /* mid q */ . <Q> // trail q
+R = c!1.<R>
+  + /* before branch */ c!2.<R> // trail branch
+%%
+`, `%%
+// lead P
+P(x) = if x > 0 {
+           // lead send
+           a!x /* mid */.<P(x-1)> // trail send
+       } else {
+           // lead else
+           b!x
+       } // trail if
+Q = a?y /* mid q */.<Q> // trail q
+R = c!1.<R>
+  + /* before branch */ c!2.<R> // trail branch
+%%
+`},
+		{"braces", `%%
+chan a [1] /* ch */ // chan
+P(x) = if x /* cond */ > 0 { // open
+           a!x
+           // close then
+       } else { // open else
+           b!x // trail b
+           /* close else */
+       }
+Q = a?y.(if y > 1 { // in paren
+             b!1
+         }
+       + c!1 // last branch
+       ).<Q> // after q
+S = (a!1 /* in */ + b!2).<S>
+
+// end comment
+%%
+`, `%%
+chan a [1] /* ch */ // chan
+P(x) = if x > 0 { /* cond */ // open
+           a!x
+           // close then
+       } else { // open else
+           b!x // trail b
+           /* close else */
+       }
+Q = a?y.(if y > 1 { // in paren
+             b!1
+         }
+       + c!1).<Q> // last branch
+// after q
+S = (a!1 /* in */ + b!2).<S>
+
+// end comment
+%%
+`},
+		{"sends", `%%
+P(x,y) = b!(x+(y-1)).a!(x).c!(x,y-1).<P(x,y)>
+%%
+`, `%%
+P(x,y) = b!(x+(y-1)).a!x.c!(x,y-1).<P(x,y)>
+%%
+`},
+		{"else if", `%%
+P(x) = if x > 0 { a!1 } else if x < 0 { b!1 } else { if x == 0 { c!1 } }.<P(x)>
+%%
+`, `%%
+P(x) = if x > 0 {
+           a!1
+       } else if x < 0 {
+           b!1
+       } else {
+           if x == 0 {
+               c!1
+           }
+       }.<P(x)>
+%%
+`},
+	}
+	for _, test := range tests {
+		got, err := Format("test.tz", []byte(test.src), builtins)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+		again, err := Format("test.tz", got, builtins)
+		if err != nil {
+			t.Errorf("%s, formatted again: %v", test.name, err)
+			continue
+		}
+		if string(again) != string(got) {
+			t.Errorf("%s: formatting again gives\n%s\ninstead of\n%s", test.name, again, got)
+		}
+	}
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goBuiltin tells how a builtin known to the parser is spelled in Go.
+type goBuiltin struct {
+	pkg string // the package to import
+	fun string // the qualified function
+}
+
+// goBuiltins maps the builtins handed to Parse to their Go counterparts.
+var goBuiltins = map[string]goBuiltin{
+	"printf": {"fmt", "fmt.Printf"},
+}
+
+// chanInfo describes a channel of the generated program.
+type chanInfo struct {
+	name  string        // the channel, in the model and in Go
+	arity int           // the number of values in each message
+	decl  *ChanDeclNode // the declaration of an asynchronous channel; nil if synchronous
+	first Node          // the action that fixed the arity of an undeclared channel
+	types []string      // the Go type of each value in a message
+}
+
+// elemType returns the Go type of the messages on the channel.
+func (c *chanInfo) elemType() string {
+	switch c.arity {
+	case 0:
+		return "struct{}"
+	case 1:
+		return c.types[0]
+	}
+	return c.name + "Msg"
+}
+
+// capacity returns the buffer length of the channel, the smallest size in
+// its declaration, or 0 for a synchronous channel.
+func (c *chanInfo) capacity() int {
+	if c.decl == nil {
+		return 0
+	}
+	capacity := int(c.decl.Sizes[0].Int64)
+	for _, size := range c.decl.Sizes[1:] {
+		if int(size.Int64) < capacity {
+			capacity = int(size.Int64)
+		}
+	}
+	return capacity
+}
+
+// generator holds the state of a translation to Go.
+type generator struct {
+	tree    *Tree                   // the tree of the whole input, for error reports
+	defs    []*ProcDefNode          // process definitions in lexical order
+	procs   map[string]*ProcDefNode // process definitions by name
+	chans   map[string]*chanInfo    // channels by name
+	types   *Types                  // the types inferred for the model
+	helpers []*GoBlockNode          // the @@ blocks, copied verbatim
+	global  map[string]bool         // names declared at package level
+	imports map[string]bool         // packages used by the generated code
+	spawned string                  // the WaitGroup of the processes started before a dot; empty if none
+	fn      *genFunc                // the function being generated
+}
+
+// genFunc holds the state of the Go function generated for one process.
+type genFunc struct {
+	def    *ProcDefNode
+	params []string        // the Go names of the parameters
+	names  map[string]bool // Go names declared in the function
+	used   map[string]bool // Go names referred to
+	loop   bool            // the process calls itself last, so its body runs in a loop
+}
+
+// genError is the panic value used to unwind a failed translation.
+type genError struct {
+	error
+}
+
+// GenGo translates the processes parsed into treeSet from the input called
+// name into a gofmt'd Go program whose main runs the process root called
+// with args.
+// Every process definition becomes a function and a call in last position
+// to the process itself becomes a loop. Channels declared with chan become
+// buffered Go channels and all others unbuffered ones. '<P||Q>' runs P and Q
+// in goroutines and waits for both, while '<P>.Q' starts P in a goroutine
+// and goes on with Q, main waiting for P before it returns. A choice
+// becomes a select statement and the @@ blocks are copied verbatim.
+// The types of parameters and messages are those InferTypes finds.
+func GenGo(treeSet map[string]*Tree, name, root string, args []Value) (src []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			ge, ok := e.(genError)
+			if !ok {
+				panic(e)
+			}
+			err = ge.error
+		}
+	}()
+
+	file := treeSet[fileKey(name)]
+	if file == nil {
+		return nil, fmt.Errorf("gen: no input named %q", name)
+	}
+	g := &generator{
+		tree:    file,
+		procs:   make(map[string]*ProcDefNode),
+		chans:   make(map[string]*chanInfo),
+		global:  map[string]bool{"main": true},
+		imports: make(map[string]bool),
+	}
+	g.collect()
+	if g.types, err = InferTypes(file); err != nil {
+		return nil, err
+	}
+	for _, c := range g.chans {
+		for i := 0; i < c.arity; i++ {
+			c.types = append(c.types, g.types.Value(c.name, i))
+		}
+	}
+	def := g.procs[root]
+	if def == nil {
+		return nil, fmt.Errorf("gen: no process named %q", root)
+	}
+	if len(args) != len(def.Params) {
+		return nil, fmt.Errorf("gen: process %s takes %d arguments, not %d", root, len(def.Params), len(args))
+	}
+	call := make([]string, len(args))
+	for i, v := range args {
+		typ, ok := g.types.Param(def, i), true
+		switch v.(type) {
+		case int64:
+			ok = isNumeric(typ)
+		case bool:
+			ok = typ == "bool"
+		case string:
+			ok = typ == "string"
+		}
+		if !ok {
+			return nil, fmt.Errorf("gen: argument %d of %s, %s, is not %s", i+1, root, formatValue(v), article(typ))
+		}
+		call[i] = formatValue(v)
+	}
+
+	body := g.program(fmt.Sprintf("%s(%s)", root, strings.Join(call, ", ")))
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by tozzy from %s. DO NOT EDIT.\n\npackage main\n\n", name)
+	if len(g.imports) > 0 {
+		var pkgs []string
+		for pkg := range g.imports {
+			pkgs = append(pkgs, strconv.Quote(pkg))
+		}
+		sort.Strings(pkgs)
+		fmt.Fprintf(&b, "import (\n%s\n)\n\n", strings.Join(pkgs, "\n"))
+	}
+	b.WriteString(body)
+	src, err = format.Source(b.Bytes())
+	if err != nil {
+		return b.Bytes(), fmt.Errorf("gen: formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+// errorf reports an error at the node and stops the translation.
+func (g *generator) errorf(n Node, format string, args ...interface{}) {
+	panic(genError{Diagnostics{g.tree.diagnostic(SeverityError, n, format, args...)}})
+}
+
+// collect gathers the definitions, channels and helpers of the input and
+// works out how many values each channel carries.
+func (g *generator) collect() {
+	for _, n := range g.tree.root.Nodes {
+		switch n := n.(type) {
+		case *GoBlockNode:
+			g.helpers = append(g.helpers, n)
+			for _, fn := range n.Funcs {
+				g.global[fn.Name.Name] = true
+			}
+		case *ChanDeclNode:
+			g.chans[n.Name.Ident] = &chanInfo{name: n.Name.Ident, arity: len(n.Sizes), decl: n}
+		case *ProcDefNode:
+			g.defs = append(g.defs, n)
+			g.procs[n.Name] = n
+		}
+	}
+	for _, def := range g.defs {
+		if g.global[def.Name] {
+			g.errorf(def, "process %s has the name of a Go helper", def.Name)
+		}
+		if g.chans[def.Name] != nil {
+			g.errorf(def, "process %s has the name of a channel", def.Name)
+		}
+		g.global[def.Name] = true
+	}
+	for _, def := range g.defs {
+		Inspect(def.Body, func(n Node) bool {
+			switch n := n.(type) {
+			case *SendNode:
+				g.useChan(n, n.Chan, len(n.Args), false)
+			case *AsyncSendNode:
+				g.useChan(n, n.Chan, len(n.Args), true)
+			case *ReceiveNode:
+				g.useChan(n, n.Chan, len(n.Vars), false)
+			case *AsyncReceiveNode:
+				g.useChan(n, n.Chan, len(n.Vars), true)
+			}
+			return true
+		})
+	}
+	for name := range g.chans {
+		if g.global[name] {
+			g.errorf(g.chans[name].first, "channel %s has the name of a process or Go helper", name)
+		}
+		g.global[name] = true
+	}
+	for _, def := range g.defs {
+		Inspect(def.Body, func(n Node) bool {
+			if p, ok := n.(*PrefixNode); ok && isInvocation(p.Left) && g.spawned == "" {
+				g.imports["sync"] = true
+				g.spawned = "spawned"
+				for i := 1; g.global[g.spawned]; i++ {
+					g.spawned = fmt.Sprintf("spawned%d", i)
+				}
+				g.global[g.spawned] = true
+			}
+			return g.spawned == ""
+		})
+	}
+}
+
+// useChan records an action carrying arity values on the channel.
+func (g *generator) useChan(action Node, ch *IdentifierNode, arity int, async bool) {
+	c := g.chans[ch.Ident]
+	switch {
+	case c == nil && async:
+		g.errorf(action, "asynchronous action on undeclared channel %s", ch)
+	case c == nil:
+		g.chans[ch.Ident] = &chanInfo{name: ch.Ident, arity: arity, first: action}
+		return
+	case c.decl != nil && !async:
+		g.errorf(action, "synchronous action on asynchronous channel %s", ch)
+	}
+	if c.first == nil {
+		c.first = action
+	}
+	if arity != c.arity {
+		d := g.tree.diagnostic(SeverityError, action, "channel %s carries %s here but %s elsewhere", ch, values(arity), values(c.arity))
+		if c.decl != nil {
+			d.note(g.tree, c.decl, "channel %s is declared with %s here", ch, values(c.arity))
+		} else {
+			d.note(g.tree, c.first, "channel %s first carries %s here", ch, values(c.arity))
+		}
+		panic(genError{Diagnostics{d}})
+	}
+}
+
+// program generates the declarations of the program, whose main runs the
+// call root.
+func (g *generator) program(root string) string {
+	var b bytes.Buffer
+	for _, h := range g.helpers {
+		fmt.Fprintf(&b, "%s\n\n", strings.TrimSpace(h.Text))
+	}
+	var names []string
+	for name := range g.chans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := g.chans[name]
+		if c.arity > 1 {
+			fields := make([]string, c.arity)
+			for i, typ := range c.types {
+				fields[i] = fmt.Sprintf("v%d %s\n", i, typ)
+			}
+			fmt.Fprintf(&b, "type %s struct {\n%s}\n\n", c.elemType(), strings.Join(fields, ""))
+		}
+		if capacity := c.capacity(); capacity > 0 {
+			fmt.Fprintf(&b, "var %s = make(chan %s, %d)\n\n", name, c.elemType(), capacity)
+		} else {
+			fmt.Fprintf(&b, "var %s = make(chan %s)\n\n", name, c.elemType())
+		}
+	}
+	for _, def := range g.defs {
+		b.WriteString(g.function(def))
+		b.WriteString("\n")
+	}
+	if g.spawned != "" {
+		fmt.Fprintf(&b, "var %s sync.WaitGroup\n\n", g.spawned)
+		fmt.Fprintf(&b, "func main() {\n%s\n%s.Wait()\n}\n", root, g.spawned)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "func main() {\n%s\n}\n", root)
+	return b.String()
+}
+
+// function generates the Go function for a process definition.
+func (g *generator) function(def *ProcDefNode) string {
+	g.fn = &genFunc{def: def, names: make(map[string]bool), used: make(map[string]bool)}
+	env := make(map[string]string)
+	for _, param := range def.Params {
+		name := g.declare(param.Ident)
+		env[param.Ident] = name
+		g.fn.params = append(g.fn.params, name)
+	}
+	body := g.proc(def.Body, env, true)
+	if g.fn.loop {
+		if fallsThrough(body) {
+			body += "return\n"
+		}
+		body = "for {\n" + body + "}\n"
+	}
+	// Consecutive parameters of the same type share it, as in x, y int.
+	var sig []string
+	for i, name := range g.fn.params {
+		if typ := g.types.Param(def, i); i+1 < len(g.fn.params) && g.types.Param(def, i+1) == typ {
+			sig = append(sig, name)
+		} else {
+			sig = append(sig, name+" "+typ)
+		}
+	}
+	return fmt.Sprintf("func %s(%s) {\n%s}\n", def.Name, strings.Join(sig, ", "), body)
+}
+
+// fallsThrough reports whether the statements body of a loop may end other
+// than by a continue or a return, so that a return must follow them.
+func fallsThrough(body string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\nfunc f() {\n"+body+"}\n", 0)
+	if err != nil {
+		return true
+	}
+	list := f.Decls[0].(*ast.FuncDecl).Body.List
+	return len(list) == 0 || !terminates(list[len(list)-1])
+}
+
+// terminates reports whether no statement can follow s: whether it is a
+// terminating statement as the Go specification defines it, or a continue.
+// The generated code has no break, so every for without a condition and
+// every select whose cases all terminate is one.
+func terminates(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return s.Tok == token.CONTINUE || s.Tok == token.GOTO
+	case *ast.BlockStmt:
+		return len(s.List) > 0 && terminates(s.List[len(s.List)-1])
+	case *ast.IfStmt:
+		return s.Else != nil && terminates(s.Body) && terminates(s.Else)
+	case *ast.SelectStmt:
+		for _, c := range s.Body.List {
+			body := c.(*ast.CommClause).Body
+			if len(body) == 0 || !terminates(body[len(body)-1]) {
+				return false
+			}
+		}
+		return true
+	case *ast.ForStmt:
+		return s.Cond == nil
+	}
+	return false
+}
+
+// declare returns a fresh Go name for the model variable name, so that no
+// variable of the function shadows another or a package-level name.
+func (g *generator) declare(name string) string {
+	base := name
+	if token.IsKeyword(base) {
+		base += "_"
+	}
+	goName := base
+	for i := 1; g.fn.names[goName] || g.global[goName]; i++ {
+		goName = fmt.Sprintf("%s%d", base, i)
+	}
+	g.fn.names[goName] = true
+	return goName
+}
+
+// proc generates the statements running the process n in the environment
+// env, which maps model variables to Go names. If tail is set nothing runs
+// after n in the current function.
+func (g *generator) proc(n Node, env map[string]string, tail bool) string {
+	switch n := n.(type) {
+	case *NilNode:
+		return ""
+	case *SendNode:
+		return g.send(n.Chan, n.Args, env)
+	case *AsyncSendNode:
+		return g.send(n.Chan, n.Args, env)
+	case *ReceiveNode:
+		recv, rest := g.receive(n.Chan.Ident, n.Chan, n.Vars, env, nil, tail)
+		return recv + "\n" + rest
+	case *AsyncReceiveNode:
+		recv, rest := g.receive(n.Chan.Ident, n.Chan, n.Vars, env, nil, tail)
+		return recv + "\n" + rest
+	case *PrefixNode:
+		// A receive binds its variables in the continuation.
+		switch left := n.Left.(type) {
+		case *ReceiveNode:
+			recv, rest := g.receive(left.Chan.Ident, left.Chan, left.Vars, env, []Node{n.Right}, tail)
+			return recv + "\n" + rest
+		case *AsyncReceiveNode:
+			recv, rest := g.receive(left.Chan.Ident, left.Chan, left.Vars, env, []Node{n.Right}, tail)
+			return recv + "\n" + rest
+		}
+		if isInvocation(n.Left) {
+			return g.spawn(n.Left, env) + g.proc(n.Right, env, tail)
+		}
+		return g.proc(n.Left, env, false) + g.proc(n.Right, env, tail)
+	case *ChoiceNode:
+		return g.choice(n, env, tail)
+	case *IfNode:
+		return g.ifStmt(n, env, tail) + "\n"
+	case *ProcCallNode:
+		return g.call(n, env, tail)
+	case *ParallelNode:
+		return g.parallel(n, env)
+	}
+	g.errorf(n, "unexpected %s in process", n)
+	return ""
+}
+
+// list generates the process held by a list node.
+func (g *generator) list(l *ListNode, env map[string]string, tail bool) string {
+	var b bytes.Buffer
+	for _, n := range l.Nodes {
+		b.WriteString(g.proc(n, env, tail))
+	}
+	return b.String()
+}
+
+// send generates a send of the values args on the channel ch.
+func (g *generator) send(ch *IdentifierNode, args []Node, env map[string]string) string {
+	return fmt.Sprintf("%s <- %s\n", ch, g.message(g.chans[ch.Ident], args, env))
+}
+
+// message generates the value sent on c for the arguments args.
+func (g *generator) message(c *chanInfo, args []Node, env map[string]string) string {
+	switch len(args) {
+	case 0:
+		return "struct{}{}"
+	case 1:
+		return g.expr(args[0], env)
+	}
+	return fmt.Sprintf("%s{%s}", c.elemType(), g.exprList(args, env))
+}
+
+// seq generates the processes in conts one after the other.
+func (g *generator) seq(conts []Node, env map[string]string, tail bool) string {
+	var b bytes.Buffer
+	for i, n := range conts {
+		b.WriteString(g.proc(n, env, tail && i == len(conts)-1))
+	}
+	return b.String()
+}
+
+// receive generates a receive from the channel ch, spelled goChan in Go,
+// binding vars in the continuations conts. It returns the receive, fit for
+// a statement or a select case, and the statements that follow it.
+func (g *generator) receive(goChan string, ch *IdentifierNode, vars []*IdentifierNode, env map[string]string, conts []Node, tail bool) (recv, rest string) {
+	inner := copyEnv(env)
+	names := make([]string, len(vars))
+	for i, v := range vars {
+		names[i] = g.declare(v.Ident)
+		inner[v.Ident] = names[i]
+	}
+	rest = g.seq(conts, inner, tail)
+
+	recv = "<-" + goChan
+	lhs := make([]string, len(names))
+	used := false
+	for i, name := range names {
+		lhs[i] = "_"
+		if g.fn.used[name] {
+			lhs[i] = name
+			used = true
+		}
+	}
+	switch {
+	case !used:
+		return recv, rest
+	case len(names) == 1:
+		return fmt.Sprintf("%s := %s", names[0], recv), rest
+	}
+	msg := g.declare("msg")
+	fields := make([]string, len(names))
+	for i := range fields {
+		fields[i] = fmt.Sprintf("%s.v%d", msg, i)
+	}
+	return fmt.Sprintf("%s := %s", msg, recv), fmt.Sprintf("%s := %s\n", strings.Join(lhs, ", "), strings.Join(fields, ", ")) + rest
+}
+
+// selectCase is a case of the select statement a choice becomes.
+type selectCase struct {
+	action Node     // the send or receive the case waits for
+	guards []string // the Go conditions under which the case is enabled
+	conts  []Node   // the processes that run after the action, in order
+}
+
+// choice generates a choice between the branches of n as a select statement
+// with a case for each send or receive a branch may start with. A case that
+// is guarded by an if waits on a channel variable that stays nil, and so
+// never becomes ready, unless the guard holds.
+func (g *generator) choice(n *ChoiceNode, env map[string]string, tail bool) string {
+	var b bytes.Buffer
+	cases := g.cases(n, nil, nil, env, &b)
+	if len(cases) == 0 {
+		return b.String()
+	}
+	var clauses bytes.Buffer
+	for _, c := range cases {
+		var ch *IdentifierNode
+		var args []Node
+		var vars []*IdentifierNode
+		switch a := c.action.(type) {
+		case *SendNode:
+			ch, args = a.Chan, a.Args
+		case *AsyncSendNode:
+			ch, args = a.Chan, a.Args
+		case *ReceiveNode:
+			ch, vars = a.Chan, a.Vars
+		case *AsyncReceiveNode:
+			ch, vars = a.Chan, a.Vars
+		}
+		info := g.chans[ch.Ident]
+		goChan, val := ch.Ident, ""
+		_, isSend := c.action.(*SendNode)
+		if _, ok := c.action.(*AsyncSendNode); ok {
+			isSend = true
+		}
+		if isSend {
+			val = g.message(info, args, env)
+		}
+		if len(c.guards) > 0 {
+			goChan = g.declare(ch.Ident + "Case")
+			fmt.Fprintf(&b, "var %s chan %s\n", goChan, info.elemType())
+			enable := fmt.Sprintf("%s = %s\n", goChan, ch.Ident)
+			if isSend {
+				v := g.declare(ch.Ident + "Value")
+				fmt.Fprintf(&b, "var %s %s\n", v, info.elemType())
+				enable += fmt.Sprintf("%s = %s\n", v, val)
+				val = v
+			}
+			fmt.Fprintf(&b, "if %s {\n%s}\n", strings.Join(c.guards, " && "), enable)
+		}
+		if isSend {
+			fmt.Fprintf(&clauses, "case %s <- %s:\n%s", goChan, val, g.seq(c.conts, env, tail))
+		} else {
+			recv, rest := g.receive(goChan, ch, vars, env, c.conts, tail)
+			fmt.Fprintf(&clauses, "case %s:\n%s", recv, rest)
+		}
+	}
+	fmt.Fprintf(&b, "select {\n%s}\n", clauses.String())
+	return b.String()
+}
+
+// cases flattens the branch n of a choice into select cases enabled under
+// guards and followed by conts. A parenthesized choice followed by more,
+// (a!x+b?t).P, gives a case for each of its branches, each followed by P.
+// An if contributes the cases of its branches under the guard and under its
+// negation. Statements evaluating guards are written to b.
+func (g *generator) cases(n Node, guards []string, conts []Node, env map[string]string, b *bytes.Buffer) []selectCase {
+	switch n := n.(type) {
+	case *SendNode, *AsyncSendNode, *ReceiveNode, *AsyncReceiveNode:
+		return []selectCase{{n, guards, conts}}
+	case *PrefixNode:
+		return g.cases(n.Left, guards, append([]Node{n.Right}, conts...), env, b)
+	case *ChoiceNode:
+		var cases []selectCase
+		for _, branch := range n.Branches {
+			cases = append(cases, g.cases(branch, guards, conts, env, b)...)
+		}
+		return cases
+	case *IfNode:
+		ok := g.declare("ok")
+		fmt.Fprintf(b, "%s := %s\n", ok, g.expr(n.Cond, env))
+		cases := g.cases(n.List, append(guards[:len(guards):len(guards)], ok), conts, env, b)
+		if n.ElseList != nil {
+			cases = append(cases, g.cases(n.ElseList, append(guards[:len(guards):len(guards)], "!"+ok), conts, env, b)...)
+		}
+		if len(cases) == 0 {
+			b.WriteString("_ = " + ok + "\n")
+		}
+		return cases
+	case *ListNode:
+		var cases []selectCase
+		for _, elem := range n.Nodes {
+			cases = append(cases, g.cases(elem, guards, conts, env, b)...)
+		}
+		return cases
+	case *NilNode:
+		return nil
+	}
+	g.errorf(n, "choice branch %s cannot be a select case: it must start with a send or a receive", n)
+	return nil
+}
+
+// ifStmt generates an if statement, without the final newline so that an
+// else if can follow.
+func (g *generator) ifStmt(n *IfNode, env map[string]string, tail bool) string {
+	s := fmt.Sprintf("if %s {\n%s}", g.expr(n.Cond, env), g.list(n.List, env, tail))
+	if n.ElseList == nil {
+		return s
+	}
+	if len(n.ElseList.Nodes) == 1 {
+		if elseIf, ok := n.ElseList.Nodes[0].(*IfNode); ok {
+			return s + " else " + g.ifStmt(elseIf, env, tail)
+		}
+	}
+	return s + fmt.Sprintf(" else {\n%s}", g.list(n.ElseList, env, tail))
+}
+
+// call generates an invocation of a process. A process calling itself last
+// starts over with the new arguments.
+func (g *generator) call(n *ProcCallNode, env map[string]string, tail bool) string {
+	def := g.procs[n.Name]
+	if def == nil {
+		g.errorf(n, "process %s not defined", n.Name)
+	}
+	if tail && def == g.fn.def {
+		g.fn.loop = true
+		// Parameters passed on unchanged are left out of the assignment.
+		var params, args []string
+		for i, arg := range n.Args {
+			if i < len(g.fn.params) {
+				if a := g.expr(arg, env); a != g.fn.params[i] {
+					params = append(params, g.fn.params[i])
+					args = append(args, a)
+				}
+			}
+		}
+		if len(params) == 0 {
+			return "continue\n"
+		}
+		return fmt.Sprintf("%s = %s\ncontinue\n", strings.Join(params, ", "), strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("%s(%s)\n", n.Name, g.exprList(n.Args, env))
+}
+
+// parallel generates the parallel composition of the invocations in n: each
+// runs in a goroutine of its own and the composition ends when all have.
+func (g *generator) parallel(n *ParallelNode, env map[string]string) string {
+	g.imports["sync"] = true
+	wg := g.declare("wg")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "var %s sync.WaitGroup\n%s.Add(%d)\n", wg, wg, len(n.Procs))
+	for _, c := range n.Procs {
+		fmt.Fprintf(&b, "go func() {\ndefer %s.Done()\n%s}()\n", wg, g.call(c, env, false))
+	}
+	fmt.Fprintf(&b, "%s.Wait()\n", wg)
+	return b.String()
+}
+
+// spawn generates the start of the processes invoked by n, each in a
+// goroutine of its own that the process does not wait for. The arguments
+// are evaluated beforehand, since the process may change its variables
+// before the goroutines run.
+func (g *generator) spawn(n Node, env map[string]string) string {
+	calls := []*ProcCallNode{}
+	if p, ok := n.(*ParallelNode); ok {
+		calls = p.Procs
+	} else {
+		calls = append(calls, n.(*ProcCallNode))
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s.Add(%d)\n", g.spawned, len(calls))
+	for _, c := range calls {
+		if g.procs[c.Name] == nil {
+			g.errorf(c, "process %s not defined", c.Name)
+		}
+		args := make([]string, len(c.Args))
+		for i, arg := range c.Args {
+			switch arg.(type) {
+			case *NumberNode, *StringNode, *BoolNode:
+				args[i] = g.expr(arg, env)
+			default:
+				args[i] = g.declare("arg")
+				fmt.Fprintf(&b, "%s := %s\n", args[i], g.expr(arg, env))
+			}
+		}
+		fmt.Fprintf(&b, "go func() {\ndefer %s.Done()\n%s(%s)\n}()\n", g.spawned, c.Name, strings.Join(args, ", "))
+	}
+	return b.String()
+}
+
+// expr generates a Go expression for n.
+func (g *generator) expr(n Node, env map[string]string) string {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		name, ok := env[n.Ident]
+		if !ok {
+			g.errorf(n, "undefined: %s", n.Ident)
+		}
+		g.fn.used[name] = true
+		return name
+	case *NumberNode:
+		return n.Text
+	case *StringNode:
+		return n.Quoted
+	case *BoolNode:
+		return n.String()
+	case *UnaryExprNode:
+		if _, ok := n.X.(*BinaryExprNode); ok {
+			return fmt.Sprintf("%s(%s)", n.Op, g.expr(n.X, env))
+		}
+		return n.Op + g.expr(n.X, env)
+	case *BinaryExprNode:
+		prec := precedence(n.Op)
+		x, y := g.expr(n.X, env), g.expr(n.Y, env)
+		if xb, ok := n.X.(*BinaryExprNode); ok && precedence(xb.Op) < prec {
+			x = "(" + x + ")"
+		}
+		if yb, ok := n.Y.(*BinaryExprNode); ok && precedence(yb.Op) <= prec {
+			y = "(" + y + ")"
+		}
+		return fmt.Sprintf("%s %s %s", x, n.Op, y)
+	case *CallExprNode:
+		fun := n.Fun.Ident
+		if builtin, ok := goBuiltins[fun]; ok {
+			g.imports[builtin.pkg] = true
+			fun = builtin.fun
+		}
+		return fmt.Sprintf("%s(%s)", fun, g.exprList(n.Args, env))
+	}
+	g.errorf(n, "unexpected %s in expression", n)
+	return ""
+}
+
+// exprList generates a comma-separated list of Go expressions.
+func (g *generator) exprList(nodes []Node, env map[string]string) string {
+	s := make([]string, len(nodes))
+	for i, n := range nodes {
+		s[i] = g.expr(n, env)
+	}
+	return strings.Join(s, ", ")
+}
+
+// copyEnv returns a copy of the environment env.
+func copyEnv(env map[string]string) map[string]string {
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
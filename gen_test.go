@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestFallsThrough(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"", true},
+		{"a <- 1\n", true},
+		{"return\n", false},
+		{"continue\n", false},
+		{"select {\ncase a <- 1:\ncontinue\ncase x := <-b:\n_ = x\ncontinue\n}\n", false},
+		{"select {\ncase a <- 1:\ncontinue\ncase <-b:\n}\n", true},
+		{"if ok {\ncontinue\n} else {\nreturn\n}\n", false},
+		{"if ok {\ncontinue\n}\n", true},
+		{"for {\n}\n", false},
+	}
+	for _, test := range tests {
+		if got := fallsThrough(test.body); got != test.want {
+			t.Errorf("fallsThrough(%q) = %v, want %v", test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"strings"
+)
+
+// An unguardedCall is a call a process may make before it takes any action.
+type unguardedCall struct {
+	call *ProcCallNode
+	to   *ProcDefNode
+}
+
+// guarded checks that every recursion among defs is guarded: a process must
+// take an action before it calls itself again, directly or through other
+// processes. Otherwise running it unfolds the calls forever, as in
+// X = <X> + a!1. Each cycle of calls found is reported once.
+func (c *checker) guarded(defs []*ProcDefNode) {
+	skip := c.skippable(defs)
+	calls := make(map[*ProcDefNode][]unguardedCall)
+	for _, def := range defs {
+		c.unguarded(def.Body, skip, func(n *ProcCallNode) {
+			if to := c.procs[n.Name]; to != nil {
+				calls[def] = append(calls[def], unguardedCall{n, to})
+			}
+		})
+	}
+
+	// Tarjan's algorithm finds the strongly connected components of the
+	// graph of unguarded calls; those with a cycle are errors.
+	index := make(map[*ProcDefNode]int)
+	low := make(map[*ProcDefNode]int)
+	onStack := make(map[*ProcDefNode]bool)
+	var stack []*ProcDefNode
+	var visit func(def *ProcDefNode)
+	visit = func(def *ProcDefNode) {
+		index[def] = len(index) + 1
+		low[def] = index[def]
+		stack = append(stack, def)
+		onStack[def] = true
+		for _, e := range calls[def] {
+			if index[e.to] == 0 {
+				visit(e.to)
+				if low[e.to] < low[def] {
+					low[def] = low[e.to]
+				}
+			} else if onStack[e.to] && index[e.to] < low[def] {
+				low[def] = index[e.to]
+			}
+		}
+		if low[def] != index[def] {
+			return
+		}
+		scc := make(map[*ProcDefNode]bool)
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc[top] = true
+			if top == def {
+				break
+			}
+		}
+		c.reportCycle(defs, scc, calls)
+	}
+	for _, def := range defs {
+		if index[def] == 0 {
+			visit(def)
+		}
+	}
+}
+
+// reportCycle reports a shortest cycle of unguarded calls within the
+// component scc, from the first of its processes in lexical order, if it
+// has one.
+func (c *checker) reportCycle(defs []*ProcDefNode, scc map[*ProcDefNode]bool, calls map[*ProcDefNode][]unguardedCall) {
+	var start *ProcDefNode
+	for _, def := range defs {
+		if scc[def] {
+			start = def
+			break
+		}
+	}
+	type via struct {
+		from *ProcDefNode
+		call *ProcCallNode
+	}
+	prev := make(map[*ProcDefNode]via)
+	queue := []*ProcDefNode{start}
+	for len(queue) > 0 {
+		def := queue[0]
+		queue = queue[1:]
+		for _, e := range calls[def] {
+			if !scc[e.to] {
+				continue
+			}
+			if e.to == start {
+				path := []string{start.Name}
+				first := e.call
+				for d := def; d != start; d = prev[d].from {
+					path = append(path, d.Name)
+					first = prev[d].call
+				}
+				path = append(path, start.Name)
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				c.errorf(first, "unguarded recursion: %s calls itself again without taking an action: %s", start.Name, strings.Join(path, " -> "))
+				return
+			}
+			if _, ok := prev[e.to]; !ok {
+				prev[e.to] = via{def, e.call}
+				queue = append(queue, e.to)
+			}
+		}
+	}
+}
+
+// unguarded calls f for each call n may make before it takes an action.
+func (c *checker) unguarded(n Node, skip map[*ProcDefNode]bool, f func(*ProcCallNode)) {
+	switch n := n.(type) {
+	case *PrefixNode:
+		// The right of a process invocation starts with it.
+		c.unguarded(n.Left, skip, f)
+		if isInvocation(n.Left) || c.canSkip(n.Left, skip) {
+			c.unguarded(n.Right, skip, f)
+		}
+	case *ChoiceNode:
+		for _, branch := range n.Branches {
+			c.unguarded(branch, skip, f)
+		}
+	case *IfNode:
+		c.unguarded(n.List, skip, f)
+		if n.ElseList != nil {
+			c.unguarded(n.ElseList, skip, f)
+		}
+	case *ListNode:
+		for _, elem := range n.Nodes {
+			c.unguarded(elem, skip, f)
+			if !c.canSkip(elem, skip) {
+				return
+			}
+		}
+	case *ProcCallNode:
+		f(n)
+	case *ParallelNode:
+		for _, call := range n.Procs {
+			f(call)
+		}
+	}
+}
+
+// skippable returns the processes among defs that may finish without
+// taking an action.
+func (c *checker) skippable(defs []*ProcDefNode) map[*ProcDefNode]bool {
+	skip := make(map[*ProcDefNode]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, def := range defs {
+			if !skip[def] && c.canSkip(def.Body, skip) {
+				skip[def] = true
+				changed = true
+			}
+		}
+	}
+	return skip
+}
+
+// canSkip reports whether n may finish without taking an action, given the
+// processes known to in skip.
+func (c *checker) canSkip(n Node, skip map[*ProcDefNode]bool) bool {
+	switch n := n.(type) {
+	case *NilNode:
+		return true
+	case *PrefixNode:
+		return c.canSkip(n.Left, skip) && c.canSkip(n.Right, skip)
+	case *ChoiceNode:
+		for _, branch := range n.Branches {
+			if c.canSkip(branch, skip) {
+				return true
+			}
+		}
+	case *IfNode:
+		return n.ElseList == nil || c.canSkip(n.List, skip) || c.canSkip(n.ElseList, skip)
+	case *ListNode:
+		for _, elem := range n.Nodes {
+			if !c.canSkip(elem, skip) {
+				return false
+			}
+		}
+		return true
+	case *ProcCallNode:
+		return skip[c.procs[n.Name]]
+	case *ParallelNode:
+		for _, call := range n.Procs {
+			if !skip[c.procs[call.Name]] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
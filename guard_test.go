@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestGuarded(t *testing.T) {
+	testChecks(t, []checkTest{
+		{"guarded", "%%\nG = a!1.<G>\nH = a?_.<H> + b!1.<H>\nI(x) = if x > 0 { a!x.<I(x-1)> }\nP = a!1.<Q>\nQ = <P>\n%%\n", nil},
+		{"choice", "%%\nX = <X> + a!1\n%%\n", []string{
+			"test.tz:2:6: unguarded recursion: X calls itself again without taking an action: X -> X",
+		}},
+		{"through an if", "%%\nA(x) = if x > 0 { <B(x)> } else { a!1 }\nB(x) = <A(x-1)>\n%%\n", []string{
+			"test.tz:2:20: unguarded recursion: A calls itself again without taking an action: A -> B -> A",
+		}},
+		// A process invoked before a dot starts at once, so it guards nothing.
+		{"before a dot", "%%\nU = <V>.a!1\nV = <U>\n%%\n", []string{
+			"test.tz:2:6: unguarded recursion: U calls itself again without taking an action: U -> V -> U",
+		}},
+	})
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxUnfold bounds the calls unfolded before a process reaches an action,
+// which only an unguarded recursion such as P = <P> exceeds.
+const maxUnfold = 10000
+
+// ActionKind tells what a step of the system does.
+type ActionKind int
+
+const (
+	ActTau          ActionKind = iota // two processes synchronize on a channel
+	ActSend                           // a process sends on a channel to its environment
+	ActReceive                        // a process receives on a channel from its environment
+	ActAsyncSend                      // a process puts a message into a channel's buffer
+	ActAsyncReceive                   // a process takes a message out of a channel's buffer
+)
+
+// An Action is the label of a step of the system. A synchronization is
+// internal to the system, so it is shown as tau, but it still records the
+// channel and the values passed.
+type Action struct {
+	Kind ActionKind
+	Chan string
+	Vals []Value
+}
+
+func (a Action) String() string {
+	if a.Kind == ActTau {
+		return "tau"
+	}
+	return a.Message()
+}
+
+// Message returns the action as it would be written in a model, taking a
+// synchronization as its send.
+func (a Action) Message() string {
+	op := [...]string{ActTau: "!", ActSend: "!", ActReceive: "?", ActAsyncSend: "!!", ActAsyncReceive: "??"}[a.Kind]
+	if len(a.Vals) == 0 {
+		return a.Chan + op
+	}
+	return a.Chan + op + formatValues(a.Vals)
+}
+
+// A term is the run-time state of a process: the process node it runs in an
+// environment, or the components of a running parallel composition, followed
+// by what runs once it is done.
+type term struct {
+	proc string  // the process the term is running
+	node Node    // the process node to run; nil in a parallel term or once done
+	env  *env    // the environment of node
+	par  []*term // the components of a running parallel composition
+	next *cont   // what runs once the term is done
+}
+
+// A cont is a process node waiting for a term to finish.
+type cont struct {
+	proc string
+	node Node
+	env  *env
+	next *cont
+}
+
+// done reports whether the term has nothing left to run.
+func (t *term) done() bool {
+	return t.node == nil && t.par == nil && t.next == nil
+}
+
+// A State is a global state of the system: the running processes and the
+// messages held by the asynchronous channels. States are never modified, so
+// they can be kept and compared.
+type State struct {
+	root   *term
+	queues map[string][][]Value
+}
+
+// Done reports whether every process of the system has finished.
+func (s *State) Done() bool {
+	return s.root.done()
+}
+
+// setQueue sets the messages held by the channel ch, copying the map of
+// queues so that the state s was made from is left as it was.
+func (s *State) setQueue(ch string, q [][]Value) {
+	queues := make(map[string][][]Value, len(s.queues)+1)
+	for name, msgs := range s.queues {
+		queues[name] = msgs
+	}
+	if len(q) == 0 {
+		delete(queues, ch)
+	} else {
+		queues[ch] = q
+	}
+	s.queues = queues
+}
+
+// An offer is an action a process is ready to take.
+type offer struct {
+	kind     ActionKind
+	node     Node    // the action in the model
+	ch       string  // the channel
+	vals     []Value // the values sent
+	arity    int     // the number of values sent or received
+	proc     string  // the process offering the action
+	path     []int   // the position of the process among the parallel components
+	resolves []resolve
+	fire     func(vals []Value) *term
+}
+
+// A resolve is a choice that taking an offer settles, when the offer comes
+// from a branch running processes of its own: the choice at path is then
+// replaced by sub, the branch started, in which the offer is taken. The
+// resolves of an offer are listed from the outermost choice in.
+type resolve struct {
+	path   []int
+	choice *term // the term running the choice
+	sub    *term // the branch started
+}
+
+// who names the process making the offer and where it runs, as in P@0.1
+// for the second component of the first component of the root.
+func (o *offer) who() string {
+	if len(o.path) == 0 {
+		return o.proc
+	}
+	s := make([]string, len(o.path))
+	for i, p := range o.path {
+		s[i] = strconv.Itoa(p)
+	}
+	return o.proc + "@" + strings.Join(s, ".")
+}
+
+// A Transition is a step the system can take from a state.
+type Transition struct {
+	Action Action
+	offers []*offer // the processes taking part, the sender first
+}
+
+func (tr Transition) String() string {
+	if tr.Action.Kind == ActTau {
+		return fmt.Sprintf("%s -> %s: %s", tr.offers[0].who(), tr.offers[1].who(), tr.Action.Message())
+	}
+	return fmt.Sprintf("%s: %s", tr.offers[0].who(), tr.Action)
+}
+
+// An Interp runs the processes parsed from an input. Actions on channels
+// declared with chan go through a buffer as long as the smallest size in the
+// declaration; actions on other channels are a handshake between a sender
+// and a receiver of as many values.
+type Interp struct {
+	ev       *evaluator
+	procs    map[string]*ProcDefNode  // process definitions by name
+	chans    map[string]*ChanDeclNode // asynchronous channels by name
+	unfolded int                      // calls unfolded since the last action
+
+	// Open lets the processes also talk to their environment on the
+	// synchronous channels but those in Hide: any send may be taken on its
+	// own and any receive may take each tuple of the Inputs.
+	Open   bool
+	Inputs []Value
+	Hide   map[string]bool
+
+	ids  map[Node]int      // numbers of the process nodes, for the keys of states
+	free map[Node][]string // free variables of the process nodes, sorted
+}
+
+// NewInterp returns an interpreter for the processes parsed into treeSet
+// from the input called name. Guards and values may call the functions of
+// the @@ blocks and the builtins handed to Parse.
+func NewInterp(treeSet map[string]*Tree, name string, builtins map[string]interface{}) (*Interp, error) {
+	file := treeSet[fileKey(name)]
+	if file == nil {
+		return nil, fmt.Errorf("sim: no input named %q", name)
+	}
+	in := &Interp{
+		ev: &evaluator{
+			tree:     file,
+			helpers:  make(map[string]*ast.FuncDecl),
+			builtins: builtins,
+		},
+		procs: make(map[string]*ProcDefNode),
+		chans: make(map[string]*ChanDeclNode),
+	}
+	for _, n := range file.root.Nodes {
+		switch n := n.(type) {
+		case *GoBlockNode:
+			for _, fn := range n.Funcs {
+				in.ev.helpers[fn.Name.Name] = fn
+			}
+		case *ChanDeclNode:
+			in.chans[n.Name.Ident] = n
+		case *ProcDefNode:
+			in.procs[n.Name] = n
+		}
+	}
+	in.numberNodes(file)
+	return in, nil
+}
+
+// capacity returns the buffer length of an asynchronous channel.
+func capacity(decl *ChanDeclNode) int {
+	c := int(decl.Sizes[0].Int64)
+	for _, size := range decl.Sizes[1:] {
+		if int(size.Int64) < c {
+			c = int(size.Int64)
+		}
+	}
+	return c
+}
+
+// catch turns the panic of a failed evaluation into an error.
+func catch(err *error) {
+	if e := recover(); e != nil {
+		ee, ok := e.(evalError)
+		if !ok {
+			panic(e)
+		}
+		*err = ee.error
+	}
+}
+
+// Start returns the state in which the process root has been called with args.
+func (in *Interp) Start(root string, args []Value) (s *State, err error) {
+	defer catch(&err)
+	def := in.procs[root]
+	if def == nil {
+		return nil, fmt.Errorf("sim: no process named %q", root)
+	}
+	if len(args) != len(def.Params) {
+		return nil, fmt.Errorf("sim: process %s takes %d arguments, not %d", root, len(def.Params), len(args))
+	}
+	var e *env
+	for i, p := range def.Params {
+		e = e.bind(p.Ident, args[i])
+	}
+	return &State{root: in.settle(&term{proc: root, node: def.Body, env: e})}, nil
+}
+
+// settle runs t up to its next actions: it unfolds calls, picks the branch
+// of each if, starts parallel compositions and drops what is done.
+func (in *Interp) settle(t *term) *term {
+	in.unfolded = 0
+	return in.settleTerm(t)
+}
+
+func (in *Interp) settleTerm(t *term) *term {
+	for {
+		if t.par != nil {
+			// The components that are done are dropped and those that
+			// are compositions with nothing after them are flattened, so
+			// that the same process always gives the same term.
+			var par []*term
+			for _, c := range t.par {
+				switch {
+				case c.done():
+				case c.par != nil && c.next == nil:
+					par = append(par, c.par...)
+				default:
+					par = append(par, c)
+				}
+			}
+			switch {
+			case len(par) == 0:
+				t = &term{proc: t.proc, next: t.next}
+				continue
+			case len(par) == 1 && t.next == nil:
+				return par[0]
+			case len(par) == 1 && par[0].next == nil:
+				c := *par[0]
+				c.next = t.next
+				return &c
+			}
+			return &term{proc: t.proc, par: par, next: t.next}
+		}
+		switch n := t.node.(type) {
+		case nil, *NilNode:
+			if t.next == nil {
+				return &term{proc: t.proc}
+			}
+			t = &term{proc: t.next.proc, node: t.next.node, env: t.next.env, next: t.next.next}
+		case *ListNode:
+			t = &term{proc: t.proc, node: n.Nodes[0], env: t.env, next: t.next}
+		case *PrefixNode:
+			if isAction(n.Left) {
+				return t
+			}
+			if isInvocation(n.Left) {
+				return in.spawn(n, t)
+			}
+			t = &term{proc: t.proc, node: n.Left, env: t.env, next: &cont{t.proc, n.Right, t.env, t.next}}
+		case *IfNode:
+			t = &term{proc: t.proc, node: in.ifBranch(n, t.env), env: t.env, next: t.next}
+		case *ProcCallNode:
+			def, e := in.call(n, t.env)
+			t = &term{proc: def.Name, node: def.Body, env: e, next: t.next}
+		case *ParallelNode:
+			par := make([]*term, len(n.Procs))
+			for i, call := range n.Procs {
+				par[i] = in.settleTerm(&term{proc: t.proc, node: call, env: t.env})
+			}
+			t = &term{proc: t.proc, par: par, next: t.next}
+		default:
+			// An action or a choice.
+			return t
+		}
+	}
+}
+
+// spawn settles the term t running the prefix n, whose left is a process
+// invocation: the processes invoked run in parallel with the right of n, as
+// in <P>.<Q>.R, the same as <P||Q||R>.
+func (in *Interp) spawn(n *PrefixNode, t *term) *term {
+	left := in.settleTerm(&term{proc: t.proc, node: n.Left, env: t.env})
+	right := in.settleTerm(&term{proc: t.proc, node: n.Right, env: t.env, next: t.next})
+	return in.settleTerm(&term{proc: t.proc, par: []*term{left, right}})
+}
+
+// ifBranch returns the branch of n chosen by its condition, or nil.
+func (in *Interp) ifBranch(n *IfNode, e *env) Node {
+	if in.ev.evalBool(n.Cond, e) {
+		return n.List
+	}
+	if n.ElseList != nil {
+		return n.ElseList
+	}
+	return nil
+}
+
+// call returns the process called by n and its environment.
+func (in *Interp) call(n *ProcCallNode, e *env) (*ProcDefNode, *env) {
+	if in.unfolded++; in.unfolded > maxUnfold {
+		in.ev.errorf(n, "%s called %d times without an action: unguarded recursion", n, maxUnfold)
+	}
+	def := in.procs[n.Name]
+	if def == nil {
+		in.ev.errorf(n, "process %s not defined", n.Name)
+	}
+	if len(n.Args) != len(def.Params) {
+		in.ev.errorf(n, "process %s takes %d arguments, not %d", n.Name, len(def.Params), len(n.Args))
+	}
+	var callee *env
+	for i, p := range def.Params {
+		callee = callee.bind(p.Ident, in.ev.eval(n.Args[i], e))
+	}
+	return def, callee
+}
+
+// isInvocation reports whether n invokes processes, as in <P> or <P||Q>.
+func isInvocation(n Node) bool {
+	switch n.(type) {
+	case *ProcCallNode, *ParallelNode:
+		return true
+	}
+	return false
+}
+
+// isAction reports whether n is a send or a receive.
+func isAction(n Node) bool {
+	switch n.(type) {
+	case *SendNode, *ReceiveNode, *AsyncSendNode, *AsyncReceiveNode:
+		return true
+	}
+	return false
+}
+
+// appendPath returns a copy of path extended with i.
+func appendPath(path []int, i int) []int {
+	return append(append(make([]int, 0, len(path)+1), path...), i)
+}
+
+// offers appends the actions the settled term t is ready to take to ofs.
+// path is the position of t among the parallel components.
+func (in *Interp) offers(t *term, path []int, ofs []*offer) []*offer {
+	if t.par != nil {
+		for i, c := range t.par {
+			ofs = in.offers(c, appendPath(path, i), ofs)
+		}
+		return ofs
+	}
+	switch n := t.node.(type) {
+	case *PrefixNode:
+		return in.actionOffer(n.Left, n.Right, t, path, ofs)
+	case *ChoiceNode:
+		for _, b := range n.Branches {
+			ofs = in.branchOffers(b, t, t, path, ofs)
+		}
+		return ofs
+	}
+	if isAction(t.node) {
+		return in.actionOffer(t.node, nil, t, path, ofs)
+	}
+	return ofs
+}
+
+// branchOffers appends the actions of the branch b of the choice run by at
+// to ofs; t runs b, and what follows it. Taking one of them replaces the
+// whole choice. An if in a branch is a guard: the branch is disabled unless
+// the if picks a process.
+func (in *Interp) branchOffers(b Node, t, at *term, path []int, ofs []*offer) []*offer {
+	switch n := b.(type) {
+	case *NilNode:
+		return ofs
+	case *ListNode:
+		return in.branchOffers(n.Nodes[0], t, at, path, ofs)
+	case *ChoiceNode:
+		for _, b := range n.Branches {
+			ofs = in.branchOffers(b, t, at, path, ofs)
+		}
+		return ofs
+	case *IfNode:
+		if branch := in.ifBranch(n, t.env); branch != nil {
+			return in.branchOffers(branch, t, at, path, ofs)
+		}
+		return ofs
+	case *PrefixNode:
+		if isAction(n.Left) {
+			return in.actionOffer(n.Left, n.Right, t, path, ofs)
+		}
+		if !isInvocation(n.Left) {
+			then := &term{proc: t.proc, env: t.env, next: &cont{t.proc, n.Right, t.env, t.next}}
+			return in.branchOffers(n.Left, then, at, path, ofs)
+		}
+	}
+	if isAction(b) {
+		return in.actionOffer(b, nil, t, path, ofs)
+	}
+	// A call or a parallel composition, maybe followed by more: its
+	// actions, wherever they are in it, replace the choice by it. Its
+	// components keep their positions, so that they may synchronize with
+	// each other.
+	sub := in.settleTerm(&term{proc: t.proc, node: b, env: t.env, next: t.next})
+	for _, o := range in.offers(sub, path, nil) {
+		o.resolves = append([]resolve{{path, at, sub}}, o.resolves...)
+		ofs = append(ofs, o)
+	}
+	return ofs
+}
+
+// actionOffer appends the action taken by t to ofs; then runs afterwards,
+// with the values received bound to the variables of the action.
+func (in *Interp) actionOffer(action, then Node, t *term, path []int, ofs []*offer) []*offer {
+	o := &offer{node: action, proc: t.proc, path: path}
+	var ch *IdentifierNode
+	var args []Node
+	var vars []*IdentifierNode
+	async := false
+	switch n := action.(type) {
+	case *SendNode:
+		o.kind, ch, args = ActSend, n.Chan, n.Args
+	case *AsyncSendNode:
+		o.kind, ch, args, async = ActAsyncSend, n.Chan, n.Args, true
+	case *ReceiveNode:
+		o.kind, ch, vars = ActReceive, n.Chan, n.Vars
+	case *AsyncReceiveNode:
+		o.kind, ch, vars, async = ActAsyncReceive, n.Chan, n.Vars, true
+	}
+	o.ch = ch.Ident
+	o.arity = len(args) + len(vars)
+	switch decl := in.chans[o.ch]; {
+	case decl == nil && async:
+		in.ev.errorf(action, "asynchronous action on undeclared channel %s", ch)
+	case decl != nil && !async:
+		in.ev.errorf(action, "synchronous action on asynchronous channel %s", ch)
+	case decl != nil && len(decl.Sizes) != o.arity:
+		in.ev.errorf(action, "channel %s carries %d values, not %d", ch, len(decl.Sizes), o.arity)
+	}
+	for _, arg := range args {
+		o.vals = append(o.vals, in.ev.eval(arg, t.env))
+	}
+	o.fire = func(vals []Value) *term {
+		e := t.env
+		for i, v := range vars {
+			e = e.bind(v.Ident, vals[i])
+		}
+		return in.settle(&term{proc: t.proc, node: then, env: e, next: t.next})
+	}
+	return append(ofs, o)
+}
+
+// replace returns a copy of t in which the term at path is leaf. The copy
+// is not settled, so the paths of the other terms of t still hold.
+func replace(t *term, path []int, leaf *term) *term {
+	if len(path) == 0 {
+		return leaf
+	}
+	par := make([]*term, len(t.par))
+	copy(par, t.par)
+	par[path[0]] = replace(t.par[path[0]], path[1:], leaf)
+	return &term{proc: t.proc, par: par, next: t.next}
+}
+
+// resettle settles again the compositions of t, from the inside out, once
+// terms have been replaced in them.
+func (in *Interp) resettle(t *term) *term {
+	if t.par == nil {
+		return t
+	}
+	par := make([]*term, len(t.par))
+	for i, c := range t.par {
+		par[i] = in.resettle(c)
+	}
+	return in.settleTerm(&term{proc: t.proc, par: par, next: t.next})
+}
+
+// apart reports whether the processes at p and q run in parallel, that is
+// whether neither contains the other.
+func apart(p, q []int) bool {
+	for i := 0; i < len(p) && i < len(q); i++ {
+		if p[i] != q[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// together reports whether the offers o and r can be taken in one step:
+// whether they come from processes running in parallel, and not from two
+// branches of the same choice.
+func together(o, r *offer) bool {
+	if !apart(o.path, r.path) {
+		return false
+	}
+	for _, a := range o.resolves {
+		for _, b := range r.resolves {
+			if a.choice == b.choice && a.sub != b.sub {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// at returns the term of t at path.
+func at(t *term, path []int) *term {
+	for _, i := range path {
+		t = t.par[i]
+	}
+	return t
+}
+
+// Transitions returns the steps the system can take from s: a handshake
+// between a send and a receive on a synchronous channel in parallel
+// processes, a send on an asynchronous channel whose buffer has room and a
+// receive on one holding a message. In an open system, sends and receives on
+// synchronous channels may also be taken alone.
+func (in *Interp) Transitions(s *State) (trs []Transition, err error) {
+	defer catch(&err)
+	in.unfolded = 0
+	ofs := in.offers(s.root, nil, nil)
+	for _, o := range ofs {
+		switch o.kind {
+		case ActSend:
+			for _, r := range ofs {
+				// A send and a receive of different numbers of values, which
+				// Check warns of, do not match.
+				if r.kind != ActReceive || r.ch != o.ch || r.arity != o.arity || !together(o, r) {
+					continue
+				}
+				trs = append(trs, Transition{Action{ActTau, o.ch, o.vals}, []*offer{o, r}})
+			}
+			if in.Open && !in.Hide[o.ch] {
+				trs = append(trs, Transition{Action{ActSend, o.ch, o.vals}, []*offer{o}})
+			}
+		case ActReceive:
+			if in.Open && !in.Hide[o.ch] {
+				for _, vals := range inputTuples(in.Inputs, o.arity) {
+					trs = append(trs, Transition{Action{ActReceive, o.ch, vals}, []*offer{o}})
+				}
+			}
+		case ActAsyncSend:
+			if len(s.queues[o.ch]) < capacity(in.chans[o.ch]) {
+				trs = append(trs, Transition{Action{ActAsyncSend, o.ch, o.vals}, []*offer{o}})
+			}
+		case ActAsyncReceive:
+			if q := s.queues[o.ch]; len(q) > 0 {
+				trs = append(trs, Transition{Action{ActAsyncReceive, o.ch, q[0]}, []*offer{o}})
+			}
+		}
+	}
+	return trs, nil
+}
+
+// callString returns the call of the process root with args, as in P(1,2).
+func callString(root string, args []Value) string {
+	if len(args) == 0 {
+		return root
+	}
+	s := make([]string, len(args))
+	for i, v := range args {
+		s[i] = formatValue(v)
+	}
+	return root + "(" + strings.Join(s, ",") + ")"
+}
+
+// inputTuples returns every tuple of n values taken from inputs.
+func inputTuples(inputs []Value, n int) [][]Value {
+	tuples := [][]Value{{}}
+	for i := 0; i < n; i++ {
+		var longer [][]Value
+		for _, t := range tuples {
+			for _, v := range inputs {
+				longer = append(longer, append(t[:len(t):len(t)], v))
+			}
+		}
+		tuples = longer
+	}
+	return tuples
+}
+
+// Apply returns the state reached from s by the transition tr, which must
+// be one of the transitions of s.
+func (in *Interp) Apply(s *State, tr Transition) (next *State, err error) {
+	defer catch(&err)
+	next = &State{root: s.root, queues: s.queues}
+	for _, o := range tr.offers {
+		// The choices the offer settles are replaced by the branches it
+		// comes from, unless the other offer of the step did it.
+		for _, r := range o.resolves {
+			if at(next.root, r.path) == r.choice {
+				next.root = replace(next.root, r.path, r.sub)
+			}
+		}
+		next.root = replace(next.root, o.path, o.fire(tr.Action.Vals))
+	}
+	in.unfolded = 0
+	next.root = in.resettle(next.root)
+	switch ch := tr.Action.Chan; tr.Action.Kind {
+	case ActAsyncSend:
+		q := make([][]Value, len(s.queues[ch]), len(s.queues[ch])+1)
+		copy(q, s.queues[ch])
+		next.setQueue(ch, append(q, tr.Action.Vals))
+	case ActAsyncReceive:
+		next.setQueue(ch, s.queues[ch][1:])
+	}
+	return next, nil
+}
+
+// Simulate runs the process root called with args, letting sched pick one
+// of the enabled transitions at each step, and writes the steps to w. If
+// trace is not nil, the steps are also recorded there as JSON lines.
+// Simulate stops when every process is done, when no transition is enabled
+// or after steps steps.
+func (in *Interp) Simulate(w io.Writer, root string, args []Value, steps int, sched Scheduler, trace io.Writer) error {
+	var enc *json.Encoder
+	if trace != nil {
+		enc = json.NewEncoder(trace)
+	}
+	return in.run(w, root, args, steps, func(step int, trs []Transition) (int, error) {
+		i := sched.Pick(trs)
+		if enc != nil {
+			if err := enc.Encode(in.traceStep(step, trs[i])); err != nil {
+				return 0, err
+			}
+		}
+		return i, nil
+	})
+}
+
+// Replay runs the process root called with args along the steps of trace
+// and writes them to w. It fails at the first step the model does not
+// allow, listing the steps it allows instead.
+func (in *Interp) Replay(w io.Writer, root string, args []Value, trace []TraceStep) error {
+	return in.run(w, root, args, len(trace), func(step int, trs []Transition) (int, error) {
+		want := trace[step-1]
+		var enabled []string
+		for i, tr := range trs {
+			ts := in.traceStep(step, tr)
+			if sameStep(ts, want) {
+				return i, nil
+			}
+			enabled = append(enabled, fmt.Sprintf("\n\t%s at %s", ts, ts.Procs[0].Pos))
+		}
+		return 0, fmt.Errorf("replay: step %d: the model does not allow %s; it allows:%s", step, want, strings.Join(enabled, ""))
+	})
+}
+
+// run runs the process root called with args, taking at each step the
+// transition picked by pick, and writes the steps to w. It stops when every
+// process is done, when no transition is enabled or after steps steps.
+func (in *Interp) run(w io.Writer, root string, args []Value, steps int, pick func(step int, trs []Transition) (int, error)) error {
+	s, err := in.Start(root, args)
+	if err != nil {
+		return err
+	}
+	root = callString(root, args)
+	for step := 1; ; step++ {
+		if s.Done() {
+			fmt.Fprintf(w, "%s terminated after %d steps\n", root, step-1)
+			return nil
+		}
+		trs, err := in.Transitions(s)
+		if err != nil {
+			return err
+		}
+		if len(trs) == 0 {
+			fmt.Fprintf(w, "%s deadlocked after %d steps; blocked:\n", root, step-1)
+			return in.reportDeadlock(w, s)
+		}
+		if step > steps {
+			fmt.Fprintf(w, "%s stopped after %d steps\n", root, steps)
+			return nil
+		}
+		i, err := pick(step, trs)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%s\n", step, trs[i])
+		if s, err = in.Apply(s, trs[i]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const interpModel = `%%
+A = a!1.nil
+B = b!1.nil
+Seq = <A>.<B>
+Both = a!1.b!1.nil + b!1.a!1.nil
+S = c!1.nil
+R = c?v.d!v.nil
+X = <S||R> + t!1.nil
+P(x,y) = a!(x+y).nil
+%%
+`
+
+func TestInterp(t *testing.T) {
+	in := testInterp(t, interpModel)
+	explore := func(root string, args []Value) *LTS {
+		l, err := in.Explore(root, args, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", root, err)
+		}
+		return l
+	}
+
+	// A process invoked before a dot runs in parallel with what follows.
+	in.Open = true
+	if ok, d := Bisimilar(explore("Seq", nil), explore("Both", nil), "Seq", "Both", Strong); !ok {
+		t.Errorf("<A>.<B> does not interleave A and B: %v", d)
+	}
+
+	// A root process takes its arguments.
+	l := explore("P", []Value{int64(1), int64(2)})
+	if len(l.Edges) != 1 || l.Edges[0].Action.String() != "a!3" {
+		t.Errorf("P(1,2) takes %v, want a!3", l.Edges)
+	}
+
+	// The parallel components of a branch synchronize, resolving the choice.
+	in.Open = false
+	synced := false
+	for _, e := range explore("X", nil).Edges {
+		if e.Action.Kind == ActTau && e.Action.Chan == "c" {
+			synced = true
+		}
+	}
+	if !synced {
+		t.Errorf("the components of <S||R> in a branch do not synchronize on c")
+	}
+}
+
+func TestParseProc(t *testing.T) {
+	tests := []struct {
+		s    string
+		name string
+		args []Value
+	}{
+		{"P", "P", nil},
+		{"P()", "P", nil},
+		{"P(1,2)", "P", []Value{int64(1), int64(2)}},
+		{`P(1, true, "s")`, "P", []Value{int64(1), true, "s"}},
+	}
+	for _, test := range tests {
+		name, args, err := parseProc(test.s)
+		if err != nil || name != test.name || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("parseProc(%q) = %q, %v, %v; want %q, %v", test.s, name, args, err, test.name, test.args)
+		}
+	}
+	if _, _, err := parseProc("P(1"); err == nil {
+		t.Errorf("parseProc(%q) succeeds", "P(1")
+	}
+}
@@ -44,27 +44,36 @@ type itemType int
 const (
 	itemError itemType = iota // error occurred; value is text of error
 
+	itemAsyncReceive       	// '??'
+	itemAsyncSend          	// '!!'
 	itemBang               	// '!'
 	itemBool               	// boolean constant
-	itemChar               	// printable ASCII character; grab bag for comma etc.
 	itemCharConstant       	// character constant
 	itemColon              	// ':'
 	itemColonEquals        	// colon-equals (':=') introducing a declaration
-	itemDot                	// the cursor, spelled '.'
+	itemComma              	// ','
+	itemComment            	// a '//' or '/* */' comment (includes markers)
+	itemDivide             	// '/'
+	itemDot                	// '.' sequencing processes
 	itemEOF                	// EOF
+	itemEqual              	// '=='
 	itemEquals             	// '='
+	itemGoCode             	// Go source between '@@' markers
+	itemGreaterOrEqual     	// '>='
 	itemIdentifier         	// alphanumeric identifier not starting with '.'
 	itemLeftAngleBracket   	// '<'
 	itemLeftCurlyBracket   	// '{'
 	itemLeftDelim          	// left action delimiter
 	itemLeftParen          	// '(' inside action
 	itemLeftSquareBracket  	// '['
-	itemLogicAND           	// '&'
-	itemLogicOR            	// '|'
+	itemLessOrEqual        	// '<='
+	itemLogicAND           	// '&&'
 	itemMinus             	// '-'
+	itemModulo            	// '%'
+	itemNotEqual          	// '!='
 	itemNumber            	// simple number, including imaginary
-	itemParallel      		// '||'
-	itemPlus         		// '+' could be either non-deterministic choice or plus
+	itemParallel      		// '||', also logical or inside an expression
+	itemPlus         		// '+' either non-deterministic choice or addition; see Tree.isChoicePlus
 	itemMultiply			// '*'
 	itemQuestionMark       	// '?'
 	itemRawString          	// raw quoted string (includes quotes)
@@ -79,6 +88,7 @@ const (
 
 	// Keywords appear after all the rest. (i.e. reserved words)
 	itemKeyword // used only to delimit the keywords
+	itemChan    // chan keyword
 	itemElse    // else keyword
 	itemEnd     // end keyword
 	itemIf      // if keyword
@@ -86,6 +96,7 @@ const (
 )
 
 var key = map[string]itemType{
+	"chan": itemChan,
 	"else": itemElse,
 	"end":  itemEnd,
 	"if":   itemIf,
@@ -99,17 +110,18 @@ type stateFn func(*lexer) stateFn
 
 // lexer holds the state of the scanner.
 type lexer struct {
-	name       string    // the name of the input; used only for error reports
-	input      string    // the string being scanned
-	leftDelim  string    // start of action
-	rightDelim string    // end of action
-	state      stateFn   // the next lexing function to enter
-	pos        Pos       // current position in the input
-	start      Pos       // start position of this item
-	width      Pos       // width of last rune read from input
-	lastPos    Pos       // position of most recent item returned by nextItem
-	items      chan item // synchronous channel of scanned items
-	parenDepth int       // nesting depth of ( ) exprs
+	name        string    // the name of the input; used only for error reports
+	input       string    // the string being scanned
+	leftDelim   string    // start of action
+	rightDelim  string    // end of action
+	state       stateFn   // the next lexing function to enter
+	pos         Pos       // current position in the input
+	start       Pos       // start position of this item
+	width       Pos       // width of last rune read from input
+	lastPos     Pos       // position of most recent item returned by nextItem
+	items       chan item // synchronous channel of scanned items
+	parenDepth  int       // nesting depth of ( ) exprs
+	emitComment bool      // emit itemComment tokens.
 }
 
 // next returns the next rune in the input.
@@ -209,7 +221,7 @@ func (l *lexer) nextItem() item {
 	log.Println("nextItem()")
 
 	if _, file, _, _ := runtime.Caller(1); strings.Index(file, "parse.go") == -1 {
-		log.Fatal("\"nextItem\" should only be called by \"next\" and \"peek\" in parse.go")
+		panic("\"nextItem\" should only be called by \"next\" and \"peek\" in parse.go")
 	}
 
 	item := <-l.items
@@ -230,7 +242,7 @@ func (l *lexer) atTerminator() bool {
 		return true
 	}
 	switch r {
-	case eof, '.', ',', '|', ':', ')', '(', '!', '?', '+', '*', '-', '=',
+	case eof, '.', ',', '|', '&', ':', ')', '(', '!', '?', '+', '*', '/', '%', '-', '=',
 		'{', '}', '[', ']', '<', '>':
 		return true
 	}
@@ -253,14 +265,10 @@ func (l *lexer) scanNumber() bool {
 	if l.accept("0") && l.accept("xX") {
 		digits = "0123456789abcdefABCDEF"
 	}
-	fmt.Println(digits)
-	
 	l.acceptRun(digits)
-	if l.accept(".") {
-		if string(l.peek()) == "n" {
-			l.backup()
-			return true
-		}
+	// A '.' not followed by a digit is the prefix operator, as in "b!10.nil".
+	if l.peek() == '.' && l.pos+1 < Pos(len(l.input)) && strings.IndexByte(digits, l.input[l.pos+1]) >= 0 {
+		l.next()
 		l.acceptRun(digits)
 	}
 	
@@ -282,13 +290,17 @@ func (l *lexer) scanNumber() bool {
 func (l *lexer) run() {
 	log.Println("run()")
 
-	for l.state = lexStart; l.state != nil; {
+	if l.state == nil {
+		l.state = lexStart
+	}
+	for l.state != nil {
 		l.state = l.state(l)
 	}
 }
 
-// lex creates a new scanner for the input string.
-func lex(name, input, left, right string) *lexer {
+// lex creates a new scanner for the input string. Comments are skipped
+// unless emitComment is set.
+func lex(name, input, left, right string, emitComment bool) *lexer {
 	log.Println("lex(name, intput, leftDelim, rightDelim): Creates a new scanner for the input string..and then call go l.run()")
 
 	if left == "" {
@@ -298,16 +310,38 @@ func lex(name, input, left, right string) *lexer {
 		right = rightDelim
 	}
 	l := &lexer{
-		name:       name,
-		input:      input,
-		leftDelim:  left,
-		rightDelim: right,
-		items:      make(chan item, 0),
+		name:        name,
+		input:       input,
+		leftDelim:   left,
+		rightDelim:  right,
+		items:       make(chan item, 0),
+		emitComment: emitComment,
 	}
 	go l.run()
 	return l
 }
 
+// resume creates a scanner of the input of l that starts at pos in the
+// state state, for the parser to carry on after a syntax error.
+func (l *lexer) resume(pos Pos, state stateFn) *lexer {
+	log.Println("resume(pos, state)")
+
+	r := &lexer{
+		name:        l.name,
+		input:       l.input,
+		leftDelim:   l.leftDelim,
+		rightDelim:  l.rightDelim,
+		state:       state,
+		pos:         pos,
+		start:       pos,
+		lastPos:     pos,
+		items:       make(chan item, 0),
+		emitComment: l.emitComment,
+	}
+	go r.run()
+	return r
+}
+
 //========================================================
 // state functions
 //========================================================
@@ -316,17 +350,25 @@ const (
 	rightDelim   = "%%"
 	leftComment  = "/*"
 	rightComment = "*/"
+	lineComment  = "//"
+	goCodeMarker = "@@"
 )
 
-// lexStart scans until an opening action delimiter, "%%".
+// lexStart scans until an opening action delimiter, "%%", or the start of
+// a Go code block, "@@".
 func lexStart(l *lexer) stateFn {
 	log.Println("lexStart(lexer): Scans until an opening action delimiter, %%.")
 
 	for {
+		if strings.HasPrefix(l.input[l.pos:], goCodeMarker) {
+			l.ignore()
+			return lexGoCode
+		}
 		if strings.HasPrefix(l.input[l.pos:], l.leftDelim) {
 			if l.pos > l.start {
 				//@@				l.emit(itemText)
 				// do nothing, in fact this should be assert(false)
+				l.ignore()
 			}
 			return lexLeftDelim
 		}
@@ -338,11 +380,30 @@ func lexStart(l *lexer) stateFn {
 	if l.pos > l.start {
 		//@@		l.emit(itemText)
 		// do nothing, in fact this should be assert(false)
+		l.ignore()
 	}
 	l.emit(itemEOF)
 	return nil
 }
 
+// lexGoCode scans a block of Go source up to the closing "@@". The opening
+// marker is known to be present. The item holds the source without markers.
+func lexGoCode(l *lexer) stateFn {
+	log.Println("lexGoCode(lexer)")
+
+	l.pos += Pos(len(goCodeMarker))
+	l.ignore()
+	i := strings.Index(l.input[l.pos:], goCodeMarker)
+	if i < 0 {
+		return l.errorf("unclosed %s block", goCodeMarker)
+	}
+	l.pos += Pos(i)
+	l.emit(itemGoCode)
+	l.pos += Pos(len(goCodeMarker))
+	l.ignore()
+	return lexStart
+}
+
 // lexLeftDelim scans the left delimiter, which is known to be present.
 func lexLeftDelim(l *lexer) stateFn {
 	log.Println("lexLeftDelim(lexer): Scans the left delimiter.")
@@ -367,6 +428,12 @@ func lexMisc(l *lexer) stateFn {
 		}
 		return l.errorf("unclosed left paren")
 	}
+	if strings.HasPrefix(l.input[l.pos:], leftComment) {
+		return lexComment
+	}
+	if strings.HasPrefix(l.input[l.pos:], lineComment) {
+		return lexLineComment
+	}
 	switch r := l.next(); {
 	case r == eof:
 		return l.errorf("unclosed action")
@@ -375,9 +442,20 @@ func lexMisc(l *lexer) stateFn {
 	case isSpace(r):
 		return lexSpace
 	case r == '!':
-		l.emit(itemBang)
+		switch {
+		case l.accept("!"):
+			l.emit(itemAsyncSend)
+		case l.accept("="):
+			l.emit(itemNotEqual)
+		default:
+			l.emit(itemBang)
+		}
 	case r == '?':
-		l.emit(itemQuestionMark)
+		if l.accept("?") {
+			l.emit(itemAsyncReceive)
+		} else {
+			l.emit(itemQuestionMark)
+		}
 	case r == '+':
 		l.emit(itemPlus)
 	case r == '-':
@@ -389,13 +467,47 @@ func lexMisc(l *lexer) stateFn {
 		//		}
 		//		l.emit(itemColonEquals)
 	case r == '&':
+		if !l.accept("&") {
+			return l.errorf("expected &&")
+		}
 		l.emit(itemLogicAND)
 	case r == '|':
-		if l.next() == '|' {
-			l.emit(itemParallel)
+		if !l.accept("|") {
+			return l.errorf("expected ||")
+		}
+		l.emit(itemParallel)
+	case r == '=':
+		if l.accept("=") {
+			l.emit(itemEqual)
 		} else {
-			l.emit(itemLogicOR)
+			l.emit(itemEquals)
 		}
+	case r == '<':
+		if l.accept("=") {
+			l.emit(itemLessOrEqual)
+		} else {
+			l.emit(itemLeftAngleBracket)
+		}
+	case r == '>':
+		if l.accept("=") {
+			l.emit(itemGreaterOrEqual)
+		} else {
+			l.emit(itemRightAngleBracket)
+		}
+	case r == '.':
+		l.emit(itemDot)
+	case r == ',':
+		l.emit(itemComma)
+	case r == '*':
+		l.emit(itemMultiply)
+	case r == '/':
+		l.emit(itemDivide)
+	case r == '%':
+		l.emit(itemModulo)
+	case r == '[':
+		l.emit(itemLeftSquareBracket)
+	case r == ']':
+		l.emit(itemRightSquareBracket)
 	case r == '{':
 		l.emit(itemLeftCurlyBracket)
 	case r == '}':
@@ -432,40 +544,55 @@ func lexMisc(l *lexer) stateFn {
 		if l.parenDepth < 0 {
 			return l.errorf("unexpected right paren %#U", r)
 		}
-	case r <= unicode.MaxASCII && unicode.IsPrint(r):
-		l.emit(itemChar)
 	default:
 		return l.errorf("unrecognized character in action: %#U", r)
 	}
 	return lexMisc
 }
 
-// lexComment scans a comment. The left comment marker is known to be present.
+// lexComment scans a block comment. The left comment marker is known to be present.
 func lexComment(l *lexer) stateFn {
 	log.Println("lexComment(lexer)")
 
-	l.pos += Pos(len(leftComment))
-	i := strings.Index(l.input[l.pos:], rightComment)
+	i := strings.Index(l.input[l.pos+Pos(len(leftComment)):], rightComment)
 	if i < 0 {
 		return l.errorf("unclosed comment")
 	}
-	l.pos += Pos(i + len(rightComment))
-	if !strings.HasPrefix(l.input[l.pos:], l.rightDelim) {
-		return l.errorf("comment ends before closing delimiter")
+	l.pos += Pos(len(leftComment) + i + len(rightComment))
+	l.emitOrIgnoreComment()
+	return lexMisc
+}
+
+// lexLineComment scans a comment running to the end of the line. The line
+// comment marker is known to be present.
+func lexLineComment(l *lexer) stateFn {
+	log.Println("lexLineComment(lexer)")
 
+	l.pos += Pos(len(lineComment))
+	for r := l.peek(); r != eof && !isEndOfLine(r); r = l.peek() {
+		l.next()
 	}
-	l.pos += Pos(len(l.rightDelim))
-	l.ignore()
+	l.emitOrIgnoreComment()
 	return lexMisc
 }
 
+// emitOrIgnoreComment hands the comment just scanned to the parser if it
+// asked for comments, and drops it otherwise.
+func (l *lexer) emitOrIgnoreComment() {
+	if l.emitComment {
+		l.emit(itemComment)
+	} else {
+		l.ignore()
+	}
+}
+
 // lexRightDelim scans the right delimiter, which is known to be present.
 func lexRightDelim(l *lexer) stateFn {
 	log.Println("lexRightDelim(lexer)")
 
 	l.pos += Pos(len(l.rightDelim))
 	l.emit(itemRightDelim)
-	return lexMisc
+	return lexStart
 }
 
 // lexSpace scans a run of space characters.
@@ -9,8 +9,10 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"strconv"
+	"go/ast"
 	"log"
+	"strconv"
+	"strings"
 )
 
 var textFormat = "%s" // Changed to "%q" in tests for better error messages.
@@ -53,16 +55,31 @@ func (t NodeType) Type() NodeType {
 }
 
 const (
-	NodeBool       NodeType = iota // A boolean constant.
-	NodeDot                        // The cursor, dot.
-	nodeElse                       // An else action. Not added to tree.
-	nodeEnd                        // An end action. Not added to tree.
-	NodeIdentifier                 // An identifier; always a function name.
-	NodeIf                         // An if action.
-	NodeList                       // A list of Nodes.
-	NodeNil                        // An untyped nil constant.
-	NodeNumber                     // A numerical constant.
-	NodeString                     // A string constant.
+	NodeActionPattern NodeType = iota // A pattern of actions in a property, b?(1,_).
+	NodeAsyncReceive                  // A receive from an asynchronous channel, aa??(x,y).
+	NodeAsyncSend                     // A send on an asynchronous channel, aa!!(1,2).
+	NodeBinaryExpr                    // A binary expression, x+1.
+	NodeBool                          // A boolean constant.
+	NodeCallExpr                      // A function call, max(x,y).
+	NodeChanDecl                      // An asynchronous channel declaration, chan aa [10,10].
+	NodeChoice                        // A non-deterministic choice, P + Q.
+	NodeComment                       // A comment.
+	NodeGoBlock                       // A block of Go helper functions, @@ ... @@.
+	NodeIdentifier                    // An identifier; always a function name.
+	NodeIf                            // An if action.
+	NodeList                          // A list of Nodes.
+	NodeNil                           // The inactive process nil.
+	NodeNumber                        // A numerical constant.
+	NodeParallel                      // A parallel composition, <P||Q>.
+	NodePrefix                        // A sequential composition, P.Q.
+	NodeProcCall                      // A process invocation, <P(x,y)>.
+	NodeProcDef                       // A process definition, P(x,y) = ...
+	NodePropDecl                      // A property declaration, prop live = always eventually b!.
+	NodeReceive                       // A receive from a synchronous channel, b?(s,t).
+	NodeSend                          // A send on a synchronous channel, a!x.
+	NodeString                        // A string constant.
+	NodeTemporal                      // A temporal formula, always eventually b!.
+	NodeUnaryExpr                     // A unary expression, -x or !ok.
 )
 
 // Nodes.
@@ -107,32 +124,75 @@ func (l *ListNode) Copy() Node {
 	return l.CopyList()
 }
 
-//// TextNode holds plain text.
-//type TextNode struct {
-//	NodeType
-//	Pos
-//	Text []byte // The text; may span newlines.
-//}
-//
-//func newText(pos Pos, text string) *TextNode {
-//	return &TextNode{NodeType: NodeText, Pos: pos, Text: []byte(text)}
-//}
-//
-//func (t *TextNode) String() string {
-//	return fmt.Sprintf(textFormat, t.Text)
-//}
-//
-//func (t *TextNode) Copy() Node {
-//	return &TextNode{NodeType: NodeText, Text: append([]byte{}, t.Text...)}
-//}
+// CommentNode holds a comment.
+type CommentNode struct {
+	NodeType
+	Pos
+	Text string // Comment text, including the markers.
+}
+
+func newCommentNode(pos Pos, text string) *CommentNode {
+	return &CommentNode{NodeType: NodeComment, Pos: pos, Text: text}
+}
+
+func (c *CommentNode) String() string {
+	return c.Text
+}
+
+func (c *CommentNode) Copy() Node {
+	return newCommentNode(c.Pos, c.Text)
+}
+
+// GoBlockNode holds a block of Go source between "@@" markers. Its functions
+// may be called from guards and arguments; the source is carried verbatim
+// into generated programs.
+type GoBlockNode struct {
+	NodeType
+	Pos
+	Text  string          // The Go source, without the markers.
+	File  *ast.File       // The source as parsed by go/parser, as if in package main.
+	Funcs []*ast.FuncDecl // The functions declared, in lexical order.
+}
+
+func newGoBlockNode(pos Pos, text string, file *ast.File) *GoBlockNode {
+	g := &GoBlockNode{NodeType: NodeGoBlock, Pos: pos, Text: text, File: file}
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+			g.Funcs = append(g.Funcs, fn)
+		}
+	}
+	return g
+}
+
+func (g *GoBlockNode) String() string {
+	return goCodeMarker + g.Text + goCodeMarker
+}
+
+func (g *GoBlockNode) Copy() Node {
+	return &GoBlockNode{NodeType: NodeGoBlock, Pos: g.Pos, Text: g.Text, File: g.File, Funcs: g.Funcs}
+}
 
 // IdentifierNode holds an identifier.
 type IdentifierNode struct {
 	NodeType
 	Pos
-	Ident string // The identifier's name.
+	Ident string          // The identifier's name.
+	Ref   RefKind         // What the identifier refers to, once Check has resolved it.
+	Decl  *IdentifierNode // The parameter, received variable or channel it refers to.
 }
 
+// RefKind tells what an identifier refers to.
+type RefKind int
+
+const (
+	RefNone     RefKind = iota // Not resolved.
+	RefParam                   // A parameter of the process.
+	RefReceived                // A variable bound by a receive.
+	RefHelper                  // A function of a @@ block.
+	RefBuiltin                 // A builtin function.
+	RefChan                    // A channel.
+)
+
 // NewIdentifier returns a new IdentifierNode with the given identifier name.
 func newIdentifierNode(ident string) *IdentifierNode {
 	return &IdentifierNode{NodeType: NodeIdentifier, Ident: ident}
@@ -151,31 +211,12 @@ func (i *IdentifierNode) String() string {
 }
 
 func (i *IdentifierNode) Copy() Node {
-	return newIdentifierNode(i.Ident).SetPos(i.Pos)
-}
-
-// DotNode holds the special identifier '.'.
-type DotNode struct {
-	Pos
-}
-
-func newDotNode(pos Pos) *DotNode {
-	return &DotNode{Pos: pos}
-}
-
-func (d *DotNode) Type() NodeType {
-	return NodeDot
-}
-
-func (d *DotNode) String() string {
-	return "."
-}
-
-func (d *DotNode) Copy() Node {
-	return newDotNode(d.Pos)
+	n := newIdentifierNode(i.Ident).SetPos(i.Pos)
+	n.Ref, n.Decl = i.Ref, i.Decl
+	return n
 }
 
-// NilNode holds the special identifier 'nil' representing an untyped nil constant.
+// NilNode holds the inactive process nil, which does nothing and is done.
 type NilNode struct {
 	Pos
 }
@@ -363,84 +404,546 @@ func (s *StringNode) Copy() Node {
 	return newStringNode(s.Pos, s.Quoted, s.Text)
 }
 
-// endNode represents an {{end}} action.
-// It does not appear in the final parse tree.
-type endNode struct {
+// BranchNode is the common representation of if.
+type BranchNode struct {
+	NodeType
 	Pos
+	Line     int       // The line number in the input (deprecated; kept for compatibility)
+	Cond     Node      // The guard to be evaluated.
+	List     *ListNode // What to execute if the guard holds.
+	ElseList *ListNode // What to execute if the guard fails (nil if absent).
+}
+
+func (b *BranchNode) String() string {
+	name := ""
+	switch b.NodeType {
+	case NodeIf:
+		name = "if"
+	default:
+		panic("unknown branch type")
+	}
+	if b.ElseList != nil {
+		return fmt.Sprintf("%s %s {%s} else {%s}", name, b.Cond, b.List, b.ElseList)
+	}
+	return fmt.Sprintf("%s %s {%s}", name, b.Cond, b.List)
+}
+
+// IfNode represents an if guard and the processes it selects between.
+type IfNode struct {
+	BranchNode
+}
+
+func newIfNode(pos Pos, line int, cond Node, list, elseList *ListNode) *IfNode {
+	return &IfNode{BranchNode{NodeType: NodeIf, Pos: pos, Line: line, Cond: cond, List: list, ElseList: elseList}}
+}
+
+func (i *IfNode) Copy() Node {
+	return newIfNode(i.Pos, i.Line, i.Cond.Copy(), i.List.CopyList(), i.ElseList.CopyList())
 }
 
-func newEndNode(pos Pos) *endNode {
-	return &endNode{Pos: pos}
+// ProcDefNode holds a process definition, Name(Params) = Body.
+type ProcDefNode struct {
+	NodeType
+	Pos
+	Name   string            // The name of the process.
+	Params []*IdentifierNode // The formal parameters, in order.
+	Body   Node              // The process term the name stands for.
 }
 
-func (e *endNode) Type() NodeType {
-	return nodeEnd
+func newProcDefNode(pos Pos, name string, params []*IdentifierNode, body Node) *ProcDefNode {
+	return &ProcDefNode{NodeType: NodeProcDef, Pos: pos, Name: name, Params: params, Body: body}
 }
 
-func (e *endNode) String() string {
-	return "{{end}}"
+func (p *ProcDefNode) String() string {
+	if len(p.Params) == 0 {
+		return fmt.Sprintf("%s = %s", p.Name, p.Body)
+	}
+	return fmt.Sprintf("%s(%s) = %s", p.Name, joinNodes(identNodes(p.Params), ","), p.Body)
 }
 
-func (e *endNode) Copy() Node {
-	return newEndNode(e.Pos)
+func (p *ProcDefNode) Copy() Node {
+	return newProcDefNode(p.Pos, p.Name, copyIdents(p.Params), p.Body.Copy())
 }
 
-// elseNode represents an {{else}} action. Does not appear in the final tree.
-type elseNode struct {
+// ActionNode is the common representation of the communication actions.
+type ActionNode struct {
 	NodeType
 	Pos
-	Line int // The line number in the input (deprecated; kept for compatibility)
+	Chan *IdentifierNode // The channel acted upon.
+}
+
+// Op returns the communication operator of the action.
+func (a *ActionNode) Op() string {
+	switch a.NodeType {
+	case NodeSend:
+		return "!"
+	case NodeReceive:
+		return "?"
+	case NodeAsyncSend:
+		return "!!"
+	case NodeAsyncReceive:
+		return "??"
+	}
+	panic("unknown action type")
 }
 
-func newElseNode(pos Pos, line int) *elseNode {
-	return &elseNode{NodeType: nodeElse, Pos: pos, Line: line}
+// SendNode holds a send on a synchronous channel: a!x or a!(e1,e2).
+type SendNode struct {
+	ActionNode
+	Args []Node // The values sent.
 }
 
-func (e *elseNode) Type() NodeType {
-	return nodeElse
+func newSendNode(pos Pos, ch *IdentifierNode, args []Node) *SendNode {
+	return &SendNode{ActionNode{NodeType: NodeSend, Pos: pos, Chan: ch}, args}
 }
 
-func (e *elseNode) String() string {
-	return "{{else}}"
+func (s *SendNode) String() string {
+	// A lone value keeps its parentheses if it is an operation: a!x+1.P
+	// would not send x+1.
+	if len(s.Args) == 1 {
+		if _, ok := s.Args[0].(*BinaryExprNode); !ok {
+			return fmt.Sprintf("%s%s%s", s.Chan, s.Op(), s.Args[0])
+		}
+	}
+	return fmt.Sprintf("%s%s(%s)", s.Chan, s.Op(), joinNodes(s.Args, ","))
 }
 
-func (e *elseNode) Copy() Node {
-	return newElseNode(e.Pos, e.Line)
+func (s *SendNode) Copy() Node {
+	return newSendNode(s.Pos, s.Chan.Copy().(*IdentifierNode), copyNodes(s.Args))
 }
 
-// BranchNode is the common representation of if.
-type BranchNode struct {
+// ReceiveNode holds a receive from a synchronous channel: b?s or b?(s,t).
+// The variables are bound in the continuation of the receive.
+type ReceiveNode struct {
+	ActionNode
+	Vars []*IdentifierNode // The variables bound to the values received.
+}
+
+func newReceiveNode(pos Pos, ch *IdentifierNode, vars []*IdentifierNode) *ReceiveNode {
+	return &ReceiveNode{ActionNode{NodeType: NodeReceive, Pos: pos, Chan: ch}, vars}
+}
+
+func (r *ReceiveNode) String() string {
+	if len(r.Vars) == 1 {
+		return fmt.Sprintf("%s%s%s", r.Chan, r.Op(), r.Vars[0])
+	}
+	return fmt.Sprintf("%s%s(%s)", r.Chan, r.Op(), joinNodes(identNodes(r.Vars), ","))
+}
+
+func (r *ReceiveNode) Copy() Node {
+	return newReceiveNode(r.Pos, r.Chan.Copy().(*IdentifierNode), copyIdents(r.Vars))
+}
+
+// AsyncSendNode holds a send on an asynchronous channel: aa!!(1,2).
+// It completes as soon as the channel buffer has room.
+type AsyncSendNode struct {
+	SendNode
+}
+
+func newAsyncSendNode(pos Pos, ch *IdentifierNode, args []Node) *AsyncSendNode {
+	n := &AsyncSendNode{*newSendNode(pos, ch, args)}
+	n.NodeType = NodeAsyncSend
+	return n
+}
+
+func (s *AsyncSendNode) Copy() Node {
+	return newAsyncSendNode(s.Pos, s.Chan.Copy().(*IdentifierNode), copyNodes(s.Args))
+}
+
+// AsyncReceiveNode holds a receive from an asynchronous channel: aa??(x,y).
+// It takes the oldest message from the channel buffer.
+type AsyncReceiveNode struct {
+	ReceiveNode
+}
+
+func newAsyncReceiveNode(pos Pos, ch *IdentifierNode, vars []*IdentifierNode) *AsyncReceiveNode {
+	n := &AsyncReceiveNode{*newReceiveNode(pos, ch, vars)}
+	n.NodeType = NodeAsyncReceive
+	return n
+}
+
+func (r *AsyncReceiveNode) Copy() Node {
+	return newAsyncReceiveNode(r.Pos, r.Chan.Copy().(*IdentifierNode), copyIdents(r.Vars))
+}
+
+// PrefixNode holds the sequential composition Left.Right: Right starts once
+// Left has done its work. A process invocation on the left is started
+// rather than waited for, so <P>.<Q>.R runs P, Q and R in parallel, as
+// <P||Q||R> does.
+type PrefixNode struct {
 	NodeType
 	Pos
-	Line int // The line number in the input (deprecated; kept for compatibility)
-	//@@	Pipe     *PipeNode // The pipeline to be evaluated.
-	List     *ListNode // What to execute if the value is non-empty.
-	ElseList *ListNode // What to execute if the value is empty (nil if absent).
+	Left  Node // The process that runs first.
+	Right Node // The continuation.
 }
 
-func (b *BranchNode) String() string {
-	name := ""
-	switch b.NodeType {
-	case NodeIf:
-		name = "if"
-	default:
-		panic("unknown branch type")
+func newPrefixNode(pos Pos, left, right Node) *PrefixNode {
+	return &PrefixNode{NodeType: NodePrefix, Pos: pos, Left: left, Right: right}
+}
+
+func (p *PrefixNode) String() string {
+	return fmt.Sprintf("%s.%s", p.Left, p.Right)
+}
+
+func (p *PrefixNode) Copy() Node {
+	return newPrefixNode(p.Pos, p.Left.Copy(), p.Right.Copy())
+}
+
+// ChoiceNode holds the non-deterministic choice between its branches.
+type ChoiceNode struct {
+	NodeType
+	Pos
+	Branches []Node // The alternatives, in lexical order.
+}
+
+func newChoiceNode(pos Pos) *ChoiceNode {
+	return &ChoiceNode{NodeType: NodeChoice, Pos: pos}
+}
+
+func (c *ChoiceNode) append(n Node) {
+	c.Branches = append(c.Branches, n)
+}
+
+func (c *ChoiceNode) String() string {
+	return "(" + joinNodes(c.Branches, " + ") + ")"
+}
+
+func (c *ChoiceNode) Copy() Node {
+	n := newChoiceNode(c.Pos)
+	n.Branches = copyNodes(c.Branches)
+	return n
+}
+
+// ProcCallNode holds an invocation of a defined process with actual arguments.
+type ProcCallNode struct {
+	NodeType
+	Pos
+	Name string // The name of the process invoked.
+	Args []Node // The actual arguments.
+}
+
+func newProcCallNode(pos Pos, name string, args []Node) *ProcCallNode {
+	return &ProcCallNode{NodeType: NodeProcCall, Pos: pos, Name: name, Args: args}
+}
+
+func (c *ProcCallNode) String() string {
+	return "<" + c.call() + ">"
+}
+
+// call formats the invocation without the enclosing angle brackets.
+func (c *ProcCallNode) call() string {
+	if len(c.Args) == 0 {
+		return c.Name
 	}
-	if b.ElseList != nil {
-		return fmt.Sprintf("{{%s}}%s{{else}}%s{{end}}", name, b.List, b.ElseList)
+	return fmt.Sprintf("%s(%s)", c.Name, joinNodes(c.Args, ","))
+}
+
+func (c *ProcCallNode) Copy() Node {
+	return newProcCallNode(c.Pos, c.Name, copyNodes(c.Args))
+}
+
+// ParallelNode holds the parallel composition <P||Q||...> of process invocations.
+type ParallelNode struct {
+	NodeType
+	Pos
+	Procs []*ProcCallNode // The components, in lexical order.
+}
+
+func newParallelNode(pos Pos, procs []*ProcCallNode) *ParallelNode {
+	return &ParallelNode{NodeType: NodeParallel, Pos: pos, Procs: procs}
+}
+
+func (p *ParallelNode) String() string {
+	calls := make([]string, len(p.Procs))
+	for i, c := range p.Procs {
+		calls[i] = c.call()
 	}
-	return fmt.Sprintf("{{%s}}%s{{end}}", name, b.List)
+	return "<" + strings.Join(calls, "||") + ">"
 }
 
-// IfNode represents an {{if}} action and its commands.
-type IfNode struct {
-	BranchNode
+func (p *ParallelNode) Copy() Node {
+	procs := make([]*ProcCallNode, len(p.Procs))
+	for i, c := range p.Procs {
+		procs[i] = c.Copy().(*ProcCallNode)
+	}
+	return newParallelNode(p.Pos, procs)
 }
 
-func newIfNode(pos Pos, line int, list, elseList *ListNode) *IfNode {
-	return &IfNode{BranchNode{NodeType: NodeIf, Pos: pos, Line: line, List: list, ElseList: elseList}}
+// ChanDeclNode holds an asynchronous channel declaration, chan aa [10,10].
+// The channel carries one value per size given; each size is the buffer
+// length for that value, so the channel holds at most the smallest of them.
+type ChanDeclNode struct {
+	NodeType
+	Pos
+	Name  *IdentifierNode // The channel declared.
+	Sizes []*NumberNode   // The buffer size of each value carried.
 }
 
-func (i *IfNode) Copy() Node {
-	return newIfNode(i.Pos, i.Line, i.List.CopyList(), i.ElseList.CopyList())
+func newChanDeclNode(pos Pos, name *IdentifierNode, sizes []*NumberNode) *ChanDeclNode {
+	return &ChanDeclNode{NodeType: NodeChanDecl, Pos: pos, Name: name, Sizes: sizes}
+}
+
+func (c *ChanDeclNode) String() string {
+	sizes := make([]Node, len(c.Sizes))
+	for i, size := range c.Sizes {
+		sizes[i] = size
+	}
+	return fmt.Sprintf("chan %s [%s]", c.Name, joinNodes(sizes, ","))
+}
+
+func (c *ChanDeclNode) Copy() Node {
+	sizes := make([]*NumberNode, len(c.Sizes))
+	for i, size := range c.Sizes {
+		sizes[i] = size.Copy().(*NumberNode)
+	}
+	return newChanDeclNode(c.Pos, c.Name.Copy().(*IdentifierNode), sizes)
+}
+
+// PropDeclNode holds a property of the model, prop live = always eventually b!,
+// to be checked on its state space.
+type PropDeclNode struct {
+	NodeType
+	Pos
+	Name    string // The name of the property.
+	Formula Node   // The formula: a TemporalNode, an ActionPatternNode or a BoolNode.
+}
+
+func newPropDeclNode(pos Pos, name string, formula Node) *PropDeclNode {
+	return &PropDeclNode{NodeType: NodePropDecl, Pos: pos, Name: name, Formula: formula}
+}
+
+func (p *PropDeclNode) String() string {
+	return fmt.Sprintf("prop %s = %s", p.Name, p.Formula)
+}
+
+func (p *PropDeclNode) Copy() Node {
+	return newPropDeclNode(p.Pos, p.Name, p.Formula.Copy())
+}
+
+// TemporalNode holds a temporal formula: Op X for the operators "not",
+// "next", "always", "eventually" and "never", or X Op Y for "&&", "||",
+// "->", "until" and "unless".
+type TemporalNode struct {
+	NodeType
+	Pos
+	Op string // The operator.
+	X  Node   // The operand, or the left operand.
+	Y  Node   // The right operand; nil for a unary operator.
+}
+
+func newTemporalNode(pos Pos, op string, x, y Node) *TemporalNode {
+	return &TemporalNode{NodeType: NodeTemporal, Pos: pos, Op: op, X: x, Y: y}
+}
+
+func (t *TemporalNode) String() string {
+	if t.Y == nil {
+		if x, ok := t.X.(*TemporalNode); ok && x.Y != nil {
+			return fmt.Sprintf("%s (%s)", t.Op, x)
+		}
+		return fmt.Sprintf("%s %s", t.Op, t.X)
+	}
+	x, y := t.X.String(), t.Y.String()
+	if xt, ok := t.X.(*TemporalNode); ok && xt.Y != nil && formulaPrecedence(xt.Op) <= formulaPrecedence(t.Op) {
+		x = "(" + x + ")"
+	}
+	if yt, ok := t.Y.(*TemporalNode); ok && yt.Y != nil && formulaPrecedence(yt.Op) < formulaPrecedence(t.Op) {
+		y = "(" + y + ")"
+	}
+	return fmt.Sprintf("%s %s %s", x, t.Op, y)
+}
+
+func (t *TemporalNode) Copy() Node {
+	var y Node
+	if t.Y != nil {
+		y = t.Y.Copy()
+	}
+	return newTemporalNode(t.Pos, t.Op, t.X.Copy(), y)
+}
+
+// formulaPrecedence returns the binding strength of a binary operator of
+// formulas: -> binds loosest, then ||, && and until and unless. It returns
+// 0 for anything that is not a binary operator of formulas.
+func formulaPrecedence(op string) int {
+	switch op {
+	case "->":
+		return 1
+	case "||":
+		return 2
+	case "&&":
+		return 3
+	case "until", "unless":
+		return 4
+	}
+	return 0
+}
+
+// ActionPatternNode holds a pattern matching the actions of a model in a
+// property: b! matches every send on b, b?(1,_) every receive on b of two
+// values the first of which is 1, and aa!! and aa?? the actions on the
+// asynchronous channel aa. A synchronization matches the patterns of both its
+// send and its receive. The patterns tau, deadlock and done, with no Op,
+// match a synchronization, a deadlocked state and a finished one.
+type ActionPatternNode struct {
+	NodeType
+	Pos
+	Chan *IdentifierNode // The channel, or tau, deadlock or done.
+	Op   string          // The operator, "!", "?", "!!" or "??"; empty for tau, deadlock and done.
+	Args []Node          // The values: constants, or _ for any value. Nil matches any values.
+}
+
+func newActionPatternNode(pos Pos, ch *IdentifierNode, op string, args []Node) *ActionPatternNode {
+	return &ActionPatternNode{NodeType: NodeActionPattern, Pos: pos, Chan: ch, Op: op, Args: args}
+}
+
+func (a *ActionPatternNode) String() string {
+	switch len(a.Args) {
+	case 0:
+		if a.Args != nil {
+			return fmt.Sprintf("%s%s()", a.Chan, a.Op)
+		}
+		return a.Chan.String() + a.Op
+	case 1:
+		return fmt.Sprintf("%s%s%s", a.Chan, a.Op, a.Args[0])
+	}
+	return fmt.Sprintf("%s%s(%s)", a.Chan, a.Op, joinNodes(a.Args, ","))
+}
+
+func (a *ActionPatternNode) Copy() Node {
+	return newActionPatternNode(a.Pos, a.Chan.Copy().(*IdentifierNode), a.Op, copyNodes(a.Args))
+}
+
+// BinaryExprNode holds a binary expression X Op Y.
+type BinaryExprNode struct {
+	NodeType
+	Pos
+	Op string // The operator, as spelled in the input.
+	X  Node   // The left operand.
+	Y  Node   // The right operand.
+}
+
+func newBinaryExprNode(pos Pos, op string, x, y Node) *BinaryExprNode {
+	return &BinaryExprNode{NodeType: NodeBinaryExpr, Pos: pos, Op: op, X: x, Y: y}
+}
+
+// precedence returns the binding strength of a binary operator, following
+// Go: || binds loosest, then &&, comparisons, additive and multiplicative
+// operators. It returns 0 for anything that is not a binary operator.
+func precedence(op string) int {
+	switch op {
+	case "||":
+		return 1
+	case "&&":
+		return 2
+	case "==", "!=", "<", "<=", ">", ">=":
+		return 3
+	case "+", "-":
+		return 4
+	case "*", "/", "%":
+		return 5
+	}
+	return 0
+}
+
+func (b *BinaryExprNode) String() string {
+	prec := precedence(b.Op)
+	x, y := b.X.String(), b.Y.String()
+	if xb, ok := b.X.(*BinaryExprNode); ok && precedence(xb.Op) < prec {
+		x = "(" + x + ")"
+	}
+	if yb, ok := b.Y.(*BinaryExprNode); ok && precedence(yb.Op) <= prec {
+		y = "(" + y + ")"
+	}
+	if prec < precedence("+") {
+		return fmt.Sprintf("%s %s %s", x, b.Op, y)
+	}
+	return x + b.Op + y
+}
+
+func (b *BinaryExprNode) Copy() Node {
+	return newBinaryExprNode(b.Pos, b.Op, b.X.Copy(), b.Y.Copy())
+}
+
+// UnaryExprNode holds a unary expression Op X.
+type UnaryExprNode struct {
+	NodeType
+	Pos
+	Op string // The operator, one of "-", "+" and "!".
+	X  Node   // The operand.
+}
+
+func newUnaryExprNode(pos Pos, op string, x Node) *UnaryExprNode {
+	return &UnaryExprNode{NodeType: NodeUnaryExpr, Pos: pos, Op: op, X: x}
+}
+
+func (u *UnaryExprNode) String() string {
+	if _, ok := u.X.(*BinaryExprNode); ok {
+		return fmt.Sprintf("%s(%s)", u.Op, u.X)
+	}
+	return u.Op + u.X.String()
+}
+
+func (u *UnaryExprNode) Copy() Node {
+	return newUnaryExprNode(u.Pos, u.Op, u.X.Copy())
+}
+
+// CallExprNode holds a call of a function such as a @@ helper or a builtin.
+type CallExprNode struct {
+	NodeType
+	Pos
+	Fun  *IdentifierNode // The function called.
+	Args []Node          // The arguments.
+}
+
+func newCallExprNode(pos Pos, fun *IdentifierNode, args []Node) *CallExprNode {
+	return &CallExprNode{NodeType: NodeCallExpr, Pos: pos, Fun: fun, Args: args}
+}
+
+func (c *CallExprNode) String() string {
+	return fmt.Sprintf("%s(%s)", c.Fun, joinNodes(c.Args, ","))
+}
+
+func (c *CallExprNode) Copy() Node {
+	return newCallExprNode(c.Pos, c.Fun.Copy().(*IdentifierNode), copyNodes(c.Args))
+}
+
+// joinNodes formats the nodes and joins them with sep.
+func joinNodes(nodes []Node, sep string) string {
+	s := make([]string, len(nodes))
+	for i, n := range nodes {
+		s[i] = n.String()
+	}
+	return strings.Join(s, sep)
+}
+
+// copyNodes returns a deep copy of the nodes.
+func copyNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	c := make([]Node, len(nodes))
+	for i, n := range nodes {
+		c[i] = n.Copy()
+	}
+	return c
+}
+
+// copyIdents returns a deep copy of the identifiers.
+func copyIdents(idents []*IdentifierNode) []*IdentifierNode {
+	if idents == nil {
+		return nil
+	}
+	c := make([]*IdentifierNode, len(idents))
+	for i, id := range idents {
+		c[i] = id.Copy().(*IdentifierNode)
+	}
+	return c
+}
+
+// identNodes widens a slice of identifiers to a slice of Nodes.
+func identNodes(idents []*IdentifierNode) []Node {
+	nodes := make([]Node, len(idents))
+	for i, id := range idents {
+		nodes[i] = id
+	}
+	return nodes
 }
@@ -10,25 +10,50 @@ package main
 
 import (
 	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
 	"log"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
 // Tree is the representation of a single parsed template.
 type Tree struct {
-	name string    // name of the tozzy processes and PP/PF represented by the tree.
-	root *ListNode // top-level root of the tree.
-	text string    // input texts
+	name     string         // name of the tozzy processes and PP/PF represented by the tree.
+	root     *ListNode      // top-level root of the tree.
+	text     string         // input texts
+	Mode     Mode           // parsing mode.
+	comments []*CommentNode // comments in lexical order, with ParseComments.
+	tokens   []item         // the other tokens but spaces, in lexical order, with ParseComments.
+	sections []section      // the process sections, in lexical order.
 	// Parsing only; cleared after parse.
 	funcs     []map[string]interface{}
+	errs      Diagnostics // errors recorded so far, parsing on
 	lex       *lexer
 	token     [3]item // three-token lookahead for parser.
 	peekCount int
 }
 
-// Parse returns a map from name to parse.Tree. If an error is encountered,
-// parsing stops and an empty map is returned with the error.
+// A section is the extent of a process section, its delimiters included.
+type section struct {
+	pos, end Pos
+}
+
+// A Mode value is a set of flags (or 0). Modes control parser behavior.
+type Mode uint
+
+const (
+	ParseComments Mode = 1 << iota // keep comments, in lexical order, in the tree
+)
+
+// Parse returns a map from name to parse.Tree: the tree of each process
+// under its name and the tree of the whole input under fileKey(name). If
+// errors are encountered, an empty map is returned with the errors as
+// Diagnostics: after a syntax error in a process section, parsing resumes
+// at the next declaration, so that one run reports every error.
 func Parse(name, text, leftDelim, rightDelim string, funcs ...map[string]interface{}) (treeSet map[string]*Tree, err error) {
 	log.Println("Parse(name, text, leftDelim, rightDelim, funcs)")
 
@@ -37,15 +62,20 @@ func Parse(name, text, leftDelim, rightDelim string, funcs ...map[string]interfa
 	t.text = text
 
 	_, err = t.Parse(text, leftDelim, rightDelim, treeSet, funcs...)	// wholefile, "%%", "%%", treeset, builtins
-	
 	if err != nil {
-		_, file, line, _ := runtime.Caller(0)
-		log.Fatal("PARSE ERROR", file, " ", line+1)
+		return nil, err
 	}
 
 	return
 }
 
+// fileKey returns the key of the tree of the whole input called name in the
+// treeSet of Parse. The trees of the processes are kept under their names,
+// which are identifiers and so never hold a space.
+func fileKey(name string) string {
+	return "file " + name
+}
+
 // New allocates a new parse tree with the given name.
 func NewTree(name string, funcs ...map[string]interface{}) *Tree {
 	log.Println("NewTree(name, funcs)")
@@ -63,7 +93,7 @@ func IsEmptyTree(n Node) bool {
 	switch n := n.(type) {
 	case nil:
 		return true
-	case *IfNode:
+	case *IfNode, *ProcDefNode, *ChanDeclNode, *PropDeclNode:
 	case *ListNode:
 		for _, node := range n.Nodes {
 			if !IsEmptyTree(node) {
@@ -87,113 +117,175 @@ func (t *Tree) Parse(text, leftDelim, rightDelim string, treeSet map[string]*Tre
 	log.Println("t.Parse(text, leftDelim, rightDelim, treeSet, funcs)")
 
 	defer t.recover(&err)
-	t.startParse(funcs, lex(t.name, text, leftDelim, rightDelim))
+	t.startParse(funcs, lex(t.name, text, leftDelim, rightDelim, t.Mode&ParseComments != 0))
 	t.text = text
 
 	t.parse(treeSet)
 	
 	t.add(treeSet)
 	t.stopParse()
+	if len(t.errs) > 0 {
+		t.root = nil
+		t.errs.sort()
+		return nil, t.errs
+	}
 	return t, nil
 }
 
-func (t *Tree) lexing() {
-	log.Println("lexing()")
-	itemSlice := make([]item, 0)
+// parse is the top-level parser for a input.
+// next() and peek() gets new item from lexer.
+// It runs to EOF.
+func (t *Tree) parse(treeSet map[string]*Tree) {
+	log.Println("t.parse(treeSet): the top-level parser for an intput.")
 
+	t.root = newListNode(t.peek().pos)
 	for t.peek().typ != itemEOF {
-		if t.peek().typ == itemLeftDelim {
-
-			item := t.nextNonSpace()
-			for item.typ != itemEOF {
-				fmt.Println("================== --->", item.typ, "<---", item.val)
-				itemSlice = append(itemSlice, item)
-				
-				if item.typ == itemError {
-					fmt.Println("[ERROR OCURRED] item.typ: itemError ")
-					log.Fatal("some error while lexing... Terminate the program.")
-				}
-						
-				if item.typ == itemRightDelim {
-					break
-				}
-				item = t.nextNonSpace()
+		switch token := t.next(); token.typ {
+		case itemLeftDelim:
+			t.procSection(treeSet, token)
+		case itemGoCode:
+			if block := t.parseGoBlock(token); block != nil {
+				t.root.append(block)
 			}
-			
-			printAllItems(itemSlice)
-			log.Fatal("DONE FOR NOW")
+		default:
+			t.unexpected(token, "input")
 		}
 	}
 }
 
-func printAllItems(itemSlice []item) {
-	fmt.Println("---------- Print all the items! ------------")
-		
-	for i := 0; i < len(itemSlice); i++ {
-		fmt.Println(itemSlice[i].val, itemSlice[i].typ)
+// parseGoBlock hands a "@@" block to go/parser and registers the functions
+// it declares, so that later calls to them resolve. A syntax error in the
+// block is recorded and the block dropped, returning nil, so that the
+// parse goes on to the process sections.
+func (t *Tree) parseGoBlock(code item) *GoBlockNode {
+	log.Println("parseGoBlock(item)")
+
+	// The package clause shares the first line, so go/parser's line numbers
+	// stay relative to the start of the block.
+	const clause = "package main;"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, t.name, clause+code.val, 0)
+	if err != nil {
+		pos := code.pos
+		msg := err.Error()
+		if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+			pos += Pos(list[0].Pos.Offset - len(clause))
+			msg = list[0].Msg
+		}
+		t.reportAt(pos, pos, "in %s block: %s", goCodeMarker, msg)
+		return nil
 	}
-
-	fmt.Println("--------------------------------------------")
+	block := newGoBlockNode(code.pos, code.val, file)
+	helpers := make(map[string]interface{})
+	for _, fn := range block.Funcs {
+		if t.hasFunction(fn.Name.Name) || helpers[fn.Name.Name] != nil {
+			pos := code.pos + Pos(fset.Position(fn.Name.Pos()).Offset-len(clause))
+			t.reportAt(pos, pos+Pos(len(fn.Name.Name)), "function %q redeclared in %s block", fn.Name.Name, goCodeMarker)
+		}
+		helpers[fn.Name.Name] = fn
+	}
+	t.funcs = append(t.funcs, helpers)
+	return block
 }
 
-// parse is the top-level parser for a input.
-// next() and peek() gets new item from lexer.
-// It runs to EOF.
-func (t *Tree) parse(treeSet map[string]*Tree) {
-	log.Println("t.parse(treeSet): the top-level parser for an intput.")
+// procSection parses the process definitions up to the right delimiter.
+// The left delimiter, open, is past. A section the input ends in is
+// reported once, as unclosed.
+func (t *Tree) procSection(treeSet map[string]*Tree, open item) {
+	log.Println("procSection(treeSet, open)")
 
-	t.root = newListNode(t.peek().pos)
-	
-	t.lexing() // before, it was only for testing the lexer
-	
-	/*
-	for t.peek().typ != itemEOF {
+	for {
+		switch token := t.peekNonSpace(); {
+		case token.typ == itemRightDelim:
+			close := t.nextNonSpace()
+			t.sections = append(t.sections, section{open.pos, close.pos + Pos(len(close.val))})
+			return
+		case t.atEnd(token):
+			t.reportAt(open.pos, open.pos+Pos(len(open.val)), "unclosed process section")
+			// The lexer has stopped; what is left is the end of the input.
+			t.token[0], t.peekCount = item{itemEOF, token.pos, ""}, 1
+			return
+		}
+		t.declaration(treeSet)
+	}
+}
 
-		if t.peek().typ == itemLeftDelim {
-			delim := t.next() // get next token from lexer via channel "<-items"
+// atEnd reports whether the token is the end of the input, or the error of
+// the lexer reaching it inside a process section.
+func (t *Tree) atEnd(token item) bool {
+	return token.typ == itemEOF || token.typ == itemError && int(token.pos) == len(t.lex.input)
+}
 
-			if t.nextNonSpace().typ == itemIdentifier {
-				newT := NewTree("procDef") // name will be updated once we know it.
-				newT.text = t.text
-				newT.startParse(t.funcs, t.lex)
-				newT.parseProcDef(treeSet)
-				continue
+// declaration parses a declaration of a process section. A syntax error in
+// it is recorded and the parse skips to the next declaration.
+func (t *Tree) declaration(treeSet map[string]*Tree) {
+	log.Println("declaration(treeSet)")
+
+	decl := t.peekNonSpace().pos
+	defer func() {
+		if e := recover(); e != nil {
+			errs, ok := e.(Diagnostics)
+			if !ok {
+				panic(e)
 			}
-			t.backup2(delim)
-		}
-		n := t.itemNode() // tree node
-		fmt.Println("-----", n, "-----")
-		fmt.Println("YAYAYA")
-		if n.Type() == nodeEnd {
-			t.errorf("unexpected %s", n)
-		}
-		t.root.append(n)
-	}
-	*/
-}
-
-//@@func (t *Tree) parse(treeSet map[string]*Tree) (next Node) {
-//	t.root = newList(t.peek().pos)
-//	for t.peek().typ != itemEOF {
-//		if t.peek().typ == itemLeftDelim {
-//			delim := t.next()
-//			if t.nextNonSpace().typ == itemDefine {
-//				newT := NewTree("definition") // name will be updated once we know it.
-//				newT.text = t.text
-//				newT.startParse(t.funcs, t.lex)
-//				newT.parseDefinition(treeSet)
-//				continue
-//			}
-//			t.backup2(delim)
-//		}
-//		n := t.texts()
-//		if n.Type() == nodeEnd {
-//			t.errorf("unexpected %s", n)
-//		}
-//		t.root.append(n)
-//	}
-//	return nil
-//}
+			// A declaration cut short by the end of the input is left
+			// to procSection to report.
+			if t.atEnd(t.token[0]) {
+				t.peekCount = 1
+				return
+			}
+			t.errs = append(t.errs, errs...)
+			t.resync(errs[0].pos, decl)
+		}
+	}()
+	switch token := t.peekNonSpace(); {
+	case t.isPropDecl():
+		t.root.append(t.parsePropDecl())
+	case token.typ == itemIdentifier:
+		t.root.append(t.parseProcDef(treeSet))
+	case token.typ == itemChan:
+		t.root.append(t.parseChanDecl())
+	default:
+		t.unexpected(t.nextNonSpace(), "process section")
+	}
+}
+
+// resync restarts the lexer after a syntax error at pos in the declaration
+// starting at decl: at the first line after decl that starts a declaration,
+// Name = ..., Name(params) = ..., chan or prop, or closes the section, from
+// the line of the error on, else at the end of the input. Either way the
+// parse moves past decl, so every declaration is given up at most once.
+func (t *Tree) resync(pos, decl Pos) {
+	log.Println("resync(pos, decl)")
+
+	text, right := t.lex.input, t.lex.rightDelim
+	sync := regexp.MustCompile(`(?m)^[ \t]*([\pL_][\pL\pN_]*[ \t]*(\([^)\n]*\))?[ \t]*=($|[^=])|(chan|prop)[ \t]|` + regexp.QuoteMeta(right) + `)`)
+	next, state := Pos(len(text)), lexStart
+	for from := strings.LastIndex(text[:pos], "\n") + 1; from < len(text); {
+		loc := sync.FindStringIndex(text[from:])
+		if loc == nil {
+			break
+		}
+		line := from + loc[0]
+		if start := line + len(text[line:]) - len(strings.TrimLeft(text[line:], " \t")); Pos(start) > decl {
+			next, state = Pos(line), lexMisc
+			break
+		}
+		from += loc[1]
+	}
+	// The lexer is stopped at its first error, or else drained.
+	if last := t.token[0].typ; last != itemEOF && last != itemError {
+		go func(items chan item) {
+			for token := range items {
+				if token.typ == itemEOF || token.typ == itemError {
+					return
+				}
+			}
+		}(t.lex.items)
+	}
+	t.lex = t.lex.resume(next, state)
+	t.peekCount = 0
+}
 
 // startParse initializes the parser, using the lexer.
 func (t *Tree) startParse(funcs []map[string]interface{}, lex *lexer) {
@@ -212,79 +304,246 @@ func (t *Tree) stopParse() {
 	t.funcs = nil
 }
 
-//	token
-func (t *Tree) itemNode() Node {
-	log.Println("itemNode()")
+// parseProcDef parses a process definition and adds it to the treeSet
+// as a tree of its own:
+//	Name = process
+//	Name(param, ...) = process
+func (t *Tree) parseProcDef(treeSet map[string]*Tree) *ProcDefNode {
+	log.Println("parseProcDef(treeSet)")
+
+	const context = "process definition"
+	name := t.expect(itemIdentifier, context)
+	var params []*IdentifierNode
+	if t.peekNonSpace().typ == itemLeftParen {
+		t.nextNonSpace()
+		params = t.identList(context)
+	}
+	t.expect(itemEquals, context)
+	def := newProcDefNode(name.pos, name.val, params, t.choice())
+
+	if tree := treeSet[def.Name]; tree != nil && !IsEmptyTree(tree.root) {
+		t.reportAt(name.pos, name.pos+Pos(len(name.val)), "multiple definition of process %q", def.Name)
+	}
+	tree := NewTree(def.Name)
+	tree.text = t.text
+	tree.root = newListNode(def.Pos)
+	tree.root.append(def)
+	treeSet[def.Name] = tree
+	return def
+}
+
+// parseChanDecl parses an asynchronous channel declaration:
+//	chan name '[' size [',' size]... ']'
+func (t *Tree) parseChanDecl() *ChanDeclNode {
+	log.Println("parseChanDecl()")
+
+	const context = "channel declaration"
+	chanToken := t.expect(itemChan, context)
+	name := t.expect(itemIdentifier, context)
+	for _, n := range t.root.Nodes {
+		if decl, ok := n.(*ChanDeclNode); ok && decl.Name.Ident == name.val {
+			t.reportAt(name.pos, name.pos+Pos(len(name.val)), "multiple declaration of channel %q", name.val)
+		}
+	}
+	t.expect(itemLeftSquareBracket, context)
+	var sizes []*NumberNode
+	for {
+		token := t.expect(itemNumber, context)
+		size, err := newNumberNode(token.pos, token.val, token.typ)
+		if err != nil {
+			t.error(err)
+		}
+		if !size.IsInt || size.Int64 <= 0 {
+			t.reportAt(token.pos, token.pos+Pos(len(token.val)), "buffer size of channel %q must be a positive integer: %s", name.val, size)
+		}
+		sizes = append(sizes, size)
+		if token = t.nextNonSpace(); token.typ == itemRightSquareBracket {
+			break
+		}
+		if token.typ != itemComma {
+			t.unexpected(token, context)
+		}
+	}
+	return newChanDeclNode(chanToken.pos, newIdentifierNode(name.val).SetPos(name.pos), sizes)
+}
+
+// isPropDecl reports whether a property declaration comes next: prop is a
+// keyword only when a name follows it at the start of a declaration, so
+// that processes and variables may still be called prop.
+func (t *Tree) isPropDecl() bool {
+	log.Println("isPropDecl()")
+
+	token := t.nextNonSpace()
+	if token.typ != itemIdentifier || token.val != "prop" {
+		t.backup()
+		return false
+	}
+	next := t.peekNonSpace()
+	t.backup2(token)
+	return next.typ == itemIdentifier
+}
+
+// parsePropDecl parses a property declaration:
+//	prop Name = formula
+func (t *Tree) parsePropDecl() *PropDeclNode {
+	log.Println("parsePropDecl()")
+
+	const context = "property declaration"
+	propToken := t.nextNonSpace()
+	name := t.expect(itemIdentifier, context)
+	for _, n := range t.root.Nodes {
+		if prop, ok := n.(*PropDeclNode); ok && prop.Name == name.val {
+			t.reportAt(name.pos, name.pos+Pos(len(name.val)), "multiple declaration of property %q", name.val)
+		}
+	}
+	t.expect(itemEquals, context)
+	return newPropDeclNode(propToken.pos, name.val, t.formula())
+}
+
+// formula parses a temporal formula over the actions of the model:
+//	formula '->' formula
+//	formula '||' formula
+//	formula '&&' formula
+//	formula 'until' formula		formula 'unless' formula
+//	('not' | 'next' | 'always' | 'eventually' | 'never') formula
+//	'(' formula ')'
+//	'true' | 'false' | pattern
+// The binary operators are listed from the loosest to the tightest;
+// '->', 'until' and 'unless' group to the right, '||' and '&&' to the left.
+func (t *Tree) formula() Node {
+	log.Println("formula()")
+
+	return t.binaryFormula(1)
+}
+
+// binaryFormula parses a sequence of binary operations on formulas whose
+// operators bind at least as tightly as prec.
+func (t *Tree) binaryFormula(prec int) Node {
+	log.Println("binaryFormula(prec)")
+
+	x := t.unaryFormula()
+	for {
+		op, pos := t.formulaOp()
+		p := formulaPrecedence(op)
+		if p < prec {
+			return x
+		}
+		t.nextFormulaOp()
+		if op == "->" || op == "until" || op == "unless" {
+			x = newTemporalNode(pos, op, x, t.binaryFormula(p))
+		} else {
+			x = newTemporalNode(pos, op, x, t.binaryFormula(p+1))
+		}
+	}
+}
+
+// formulaOp returns the binary operator of formulas coming next, if any,
+// without consuming it.
+func (t *Tree) formulaOp() (string, Pos) {
+	log.Println("formulaOp()")
+
+	switch token := t.peekNonSpace(); token.typ {
+	case itemParallel:
+		return "||", token.pos
+	case itemLogicAND:
+		return "&&", token.pos
+	case itemMinus:
+		t.nextNonSpace()
+		next := t.peek()
+		t.backup2(token)
+		if next.typ == itemRightAngleBracket {
+			return "->", token.pos
+		}
+	case itemIdentifier:
+		if token.val == "until" || token.val == "unless" {
+			return token.val, token.pos
+		}
+	}
+	return "", 0
+}
+
+// nextFormulaOp consumes the operator found by formulaOp.
+func (t *Tree) nextFormulaOp() {
+	log.Println("nextFormulaOp()")
 
+	if t.nextNonSpace().typ == itemMinus {
+		t.next()
+	}
+}
+
+// unaryFormula parses a formula made of a unary operator or an atom.
+func (t *Tree) unaryFormula() Node {
+	log.Println("unaryFormula()")
+
+	const context = "formula"
 	switch token := t.nextNonSpace(); token.typ {
-	case itemBool: // boolean constant
-	case itemChar: // printable ASCII character; grab bag for comma etc.
-	case itemCharConstant: // character constant
-	case itemEOF:
-	case itemIdentifier: // alphanumeric identifier not starting with '.'
-		return newIdentifierNode(token.val)
-	case itemLeftDelim: // left action delimiter
-	case itemLeftParen: // '(' inside action
-	case itemNumber: // simple number, including imaginary
-	case itemRawString: // raw quoted string (includes quotes)
-	case itemRightDelim: // right action delimiter
-	case itemRightParen: // ')' inside action
-	case itemSpace: // run of spaces separating arguments
-	case itemString: // quoted string (includes quotes)
-	case itemVariable: // variable starting with '$', such as '$' or  '$1' or '$hello'
-
-	// Keywords appear after all the rest.
-	case itemKeyword: // used only to delimit the keywords
-	case itemColon: // ':'
-	case itemColonEquals: // colon-equals (':=') introducing a declaration
-	case itemDot: // the cursor, spelled '.'
-	case itemElse: // else keyword
-	case itemEnd: // end keyword
-	case itemEquals: // '='
-	case itemIf: // if keyword
-	case itemNil: // the untyped nil constant, easiest to treat as a keyword
+	case itemLeftParen:
+		f := t.formula()
+		t.expect(itemRightParen, context)
+		return f
+	case itemBool:
+		return newBoolNode(token.pos, token.val == "true")
+	case itemIdentifier:
+		if isActionOp(t.peek()) {
+			return t.actionPattern(token)
+		}
+		switch token.val {
+		case "not", "next", "always", "eventually", "never":
+			return newTemporalNode(token.pos, token.val, t.unaryFormula(), nil)
+		case "tau", "deadlock", "done":
+			return newActionPatternNode(token.pos, newIdentifierNode(token.val).SetPos(token.pos), "", nil)
+		}
+		t.errorAt(token.pos, token.pos+Pos(len(token.val)), "unexpected %s in %s: want an action such as %s! or an operator", token, context, token.val)
 	default:
-		t.unexpected(token, "input")
+		t.unexpected(token, context)
 	}
 	return nil
 }
 
-func (t *Tree) parseProcDef(treeSet map[string]*Tree) {
-	log.Println("parseProcDef(treeSet)")
-
-	// TODO
-
-	t.add(treeSet)
-	t.stopParse()
+// actionPattern:
+//	chan op		chan op operand		chan op '(' [value [',' value]...] ')'
+// where op is one of '!', '?', '!!' and '??' and each value is a constant
+// or _. The channel is past.
+func (t *Tree) actionPattern(ch item) *ActionPatternNode {
+	log.Println("actionPattern(item)")
+
+	const context = "action pattern"
+	op := t.next()
+	var args []Node
+	switch t.peek().typ {
+	case itemLeftParen:
+		t.next()
+		args = t.exprList(context)
+	case itemNumber, itemCharConstant, itemString, itemRawString, itemBool, itemIdentifier, itemMinus:
+		args = []Node{t.unaryExpr()}
+	}
+	for _, arg := range args {
+		Inspect(arg, func(n Node) bool {
+			switch n := n.(type) {
+			case *IdentifierNode:
+				if n.Ident != "_" || n != arg {
+					t.report(arg, "value %s of action pattern must be a constant or _", arg)
+				}
+			case *CallExprNode:
+				t.report(arg, "value %s of action pattern must be a constant or _", arg)
+			}
+			return true
+		})
+	}
+	return newActionPatternNode(ch.pos, newIdentifierNode(ch.val).SetPos(ch.pos), op.val, args)
+}
 
-	//	const context = "define clause"
-	//	name := t.expectOneOf(itemString, itemRawString, context)
-	//	var err error
-	//	t.name, err = strconv.Unquote(name.val)
-	//	if err != nil {
-	//		t.error(err)
-	//	}
-	//	t.expect(itemRightDelim, context)
-	//	var end Node
-	//	t.root, end = t.itemList()
-	//	if end.Type() != nodeEnd {
-	//		t.errorf("unexpected %s in %s", end, context)
-	//	}
-	//	t.add(treeSet)
-	//	t.stopParse()
-}
-
-// add adds tree to the treeSet.
+// add adds tree to the treeSet, under the key of its input.
 func (t *Tree) add(treeSet map[string]*Tree) {
 	log.Println("add(treeSet): Add tree to the treeSet")
 
-	tree := treeSet[t.name]
+	tree := treeSet[fileKey(t.name)]
 	if tree == nil || IsEmptyTree(tree.root) {
-		treeSet[t.name] = t
+		treeSet[fileKey(t.name)] = t
 		return
 	}
 	if !IsEmptyTree(t.root) {
-		t.errorf("tozzy: multiple definition of process %q", t.name)
+		t.errorf("multiple definition of process %q", t.name)
 	}
 }
 
@@ -307,7 +566,7 @@ func (t *Tree) next() item {
 	if t.peekCount > 0 {
 		t.peekCount--
 	} else {
-		t.token[0] = t.lex.nextItem()
+		t.token[0] = t.nextLexItem()
 	}
 	log.Println("next(): returns the next token.:", t.token[t.peekCount])
 
@@ -322,10 +581,25 @@ func (t *Tree) peek() item {
 		return t.token[t.peekCount-1]
 	}
 	t.peekCount = 1
-	t.token[0] = t.lex.nextItem() // get nextItem from lexer via channel "<-items"
+	t.token[0] = t.nextLexItem() // get nextItem from lexer via channel "<-items"
 	return t.token[0]
 }
 
+// nextLexItem returns the next item from the lexer. Comments never reach the
+// grammar: they are collected in the tree as they go by.
+func (t *Tree) nextLexItem() item {
+	for {
+		token := t.lex.nextItem()
+		if token.typ != itemComment {
+			if t.Mode&ParseComments != 0 && token.typ != itemSpace {
+				t.tokens = append(t.tokens, token)
+			}
+			return token
+		}
+		t.comments = append(t.comments, newCommentNode(token.pos, token.val))
+	}
+}
+
 // backup backs the input stream up one token.
 func (t *Tree) backup() {
 	log.Println("backup()")
@@ -383,34 +657,52 @@ func (t *Tree) peekNonSpace() (token item) {
 // Parsing.
 //======================================================================
 
-// ErrorContext returns a textual representation of the location of the node in the input text.
+// ErrorContext returns a textual representation of the location of the node in the input text,
+// as file:line:col with the line and column counting from 1.
 func (t *Tree) ErrorContext(n Node) (location, context string) {
 	log.Println("ErrorContext(Node)")
 
-	pos := int(n.Position())
-	text := t.text[:pos]
-	byteNum := strings.LastIndex(text, "\n")
-	if byteNum == -1 {
-		byteNum = pos // On first line.
-	} else {
-		byteNum++ // After the newline.
-		byteNum = pos - byteNum
-	}
-	lineNum := 1 + strings.Count(text, "\n")
+	lineNum, byteNum := lineCol(t.text, n.Position())
 	context = n.String()
 	if len(context) > 20 {
 		context = fmt.Sprintf("%.20s...", context)
 	}
-	return fmt.Sprintf("%s:%d:%d", lineNum, byteNum), context
+	return fmt.Sprintf("%s:%d:%d", t.name, lineNum, byteNum), context
 }
 
-// errorf formats the error and terminates processing.
+// errorf formats the error at the last token read and terminates processing.
 func (t *Tree) errorf(format string, args ...interface{}) {
 	log.Println("errorf(format, args)")
 
-	t.root = nil
-	format = fmt.Sprintf("template: %s:%d: %s", t.lex.lineNumber(), format)
-	panic(fmt.Errorf(format, args...))
+	pos := t.lex.lastPos
+	end := pos
+	if token := t.token[0]; token.pos == pos && token.typ != itemError {
+		end += Pos(len(token.val))
+	}
+	t.errorAt(pos, end, format, args...)
+}
+
+// errorAt formats the error at the text from pos to end and terminates
+// processing. The error is Diagnostics.
+func (t *Tree) errorAt(pos, end Pos, format string, args ...interface{}) {
+	log.Println("errorAt(pos, end, format, args)")
+
+	panic(Diagnostics{{Span: t.span(pos, end), Severity: SeverityError, Msg: fmt.Sprintf(format, args...)}})
+}
+
+// reportAt records the error at the text from pos to end; the parse goes
+// on, as the error leaves the syntax sound.
+func (t *Tree) reportAt(pos, end Pos, format string, args ...interface{}) {
+	log.Println("reportAt(pos, end, format, args)")
+
+	t.errs = append(t.errs, &Diagnostic{Span: t.span(pos, end), Severity: SeverityError, Msg: fmt.Sprintf(format, args...)})
+}
+
+// report records the error at the node n; the parse goes on.
+func (t *Tree) report(n Node, format string, args ...interface{}) {
+	log.Println("report(Node, format, args)")
+
+	t.errs = append(t.errs, t.diagnostic(SeverityError, n, format, args...))
 }
 
 // error terminates processing.
@@ -446,6 +738,9 @@ func (t *Tree) expectOneOf(expected1, expected2 itemType, context string) item {
 func (t *Tree) unexpected(token item, context string) {
 	log.Println("unexpected(item, contextString)")
 
+	if token.typ == itemError {
+		t.errorAt(token.pos, token.pos, "%s", token.val)
+	}
 	t.errorf("unexpected %s in %s", token, context)
 }
 
@@ -459,99 +754,371 @@ func (t *Tree) recover(errp *error) {
 			panic(e)
 		}
 		if t != nil {
+			t.root = nil
 			t.stopParse()
+			// The errors recovered from come with the one that stopped the parse.
+			if errs, ok := e.(Diagnostics); ok && len(t.errs) > 0 {
+				errs = append(t.errs, errs...)
+				errs.sort()
+				e = errs
+			}
 		}
 		*errp = e.(error)
 	}
 	return
 }
 
-// itemList:
-//	textOrAction*
-// Terminates at {{end}} or {{else}}, returned separately.
-func (t *Tree) itemList() (list *ListNode, next Node) {
-	log.Println("itemList()")
+// choice:
+//	prefix ['+' prefix]...
+func (t *Tree) choice() Node {
+	log.Println("choice()")
+
+	first := t.prefix()
+	if t.peekNonSpace().typ != itemPlus {
+		return first
+	}
+	choice := newChoiceNode(first.Position())
+	choice.append(first)
+	for t.peekNonSpace().typ == itemPlus {
+		t.nextNonSpace()
+		choice.append(t.prefix())
+	}
+	return choice
+}
+
+// prefix:
+//	term ['.' prefix]
+func (t *Tree) prefix() Node {
+	log.Println("prefix()")
+
+	left := t.term()
+	if t.peekNonSpace().typ == itemDot {
+		t.nextNonSpace()
+		return newPrefixNode(left.Position(), left, t.prefix())
+	}
+	return left
+}
+
+// term:
+//	action
+//	'<' call ['||' call]... '>'
+//	'(' choice ')'
+//	if
+//	nil
+func (t *Tree) term() Node {
+	log.Println("term()")
+
+	switch token := t.nextNonSpace(); {
+	case token.typ == itemIdentifier:
+		return t.action(token)
+	case token.typ == itemLeftParen:
+		n := t.choice()
+		t.expect(itemRightParen, "parenthesized process")
+		return n
+	case token.typ == itemIf:
+		return t.ifControl(token)
+	case token.typ == itemNil:
+		return newNilNode(token.pos)
+	case token.typ == itemLeftAngleBracket:
+		return t.procCalls(token)
+	default:
+		t.unexpected(token, "process")
+	}
+	return nil
+}
 
-	list = newListNode(t.peekNonSpace().pos)
-	for t.peekNonSpace().typ != itemEOF {
-		n := t.itemNode()
-		switch n.Type() {
-		case nodeEnd, nodeElse:
-			return list, n
+// action:
+//	chan '!' operand		chan '!' '(' [expr [',' expr]...] ')'
+//	chan '?' identifier		chan '?' '(' [identifier [',' identifier]...] ')'
+// and likewise for the asynchronous '!!' and '??'. The channel is past.
+func (t *Tree) action(ch item) Node {
+	log.Println("action(item)")
+
+	const context = "action"
+	op := t.next()
+	if !isActionOp(op) {
+		t.unexpected(op, context)
+	}
+	async := op.typ == itemAsyncSend || op.typ == itemAsyncReceive
+	id := newIdentifierNode(ch.val).SetPos(ch.pos)
+	if op.typ == itemBang || op.typ == itemAsyncSend {
+		var args []Node
+		if t.peekNonSpace().typ == itemLeftParen {
+			t.nextNonSpace()
+			args = t.exprList(context)
+		} else {
+			args = []Node{t.sendArg()}
+		}
+		if async {
+			return newAsyncSendNode(ch.pos, id, args)
 		}
-		list.append(n)
+		return newSendNode(ch.pos, id, args)
 	}
-	t.errorf("unexpected EOF")
-	return
+	var vars []*IdentifierNode
+	if t.peekNonSpace().typ == itemLeftParen {
+		t.nextNonSpace()
+		vars = t.identList(context)
+	} else {
+		v := t.expect(itemIdentifier, context)
+		vars = []*IdentifierNode{newIdentifierNode(v.val).SetPos(v.pos)}
+	}
+	if async {
+		return newAsyncReceiveNode(ch.pos, id, vars)
+	}
+	return newReceiveNode(ch.pos, id, vars)
 }
 
-func (t *Tree) parseControl(allowElseIf bool, context string) (pos Pos, line int, list, elseList *ListNode) {
-	log.Println("parseControl(allowElseIf, contextString)")
-
-	//@@	defer t.popVars(len(t.vars))
-	line = t.lex.lineNumber()
-	var next Node
-	list, next = t.itemList()
-	switch next.Type() {
-	case nodeEnd: //done
-	case nodeElse:
-		if allowElseIf {
-			// Special case for "else if". If the "else" is followed immediately by an "if",
-			// the elseControl will have left the "if" token pending. Treat
-			//	{{if a}}_{{else if b}}_{{end}}
-			// as
-			//	{{if a}}_{{else}}{{if b}}_{{end}}{{end}}.
-			// To do this, parse the if as usual and stop at it {{end}}; the subsequent{{end}}
-			// is assumed. This technique works even for long if-else-if chains.
-			// TODO: Should we allow else-if in with and range?
-			if t.peek().typ == itemIf {
-				t.next() // Consume the "if" token.
-				elseList = newListNode(next.Position())
-				elseList.append(t.ifControl())
-				// Do not consume the next item - only one {{end}} required.
-				break
-			}
+// isActionOp reports whether the token is one of the communication
+// operators '!', '?', '!!' and '??'.
+func isActionOp(token item) bool {
+	switch token.typ {
+	case itemBang, itemQuestionMark, itemAsyncSend, itemAsyncReceive:
+		return true
+	}
+	return false
+}
+
+// procCalls:
+//	'<' call ['||' call]... '>'
+// call:
+//	Name
+//	Name '(' [expr [',' expr]...] ')'
+// The left angle bracket is past. A single call is returned as is.
+func (t *Tree) procCalls(lt item) Node {
+	log.Println("procCalls(item)")
+
+	const context = "process invocation"
+	var procs []*ProcCallNode
+	for {
+		name := t.expect(itemIdentifier, context)
+		var args []Node
+		if t.peekNonSpace().typ == itemLeftParen {
+			t.nextNonSpace()
+			args = t.exprList(context)
+		}
+		procs = append(procs, newProcCallNode(name.pos, name.val, args))
+
+		token := t.nextNonSpace()
+		if token.typ == itemRightAngleBracket {
+			break
 		}
-		elseList, next = t.itemList()
-		if next.Type() != nodeEnd {
-			t.errorf("expected end; found %s", next)
+		if token.typ != itemParallel {
+			t.unexpected(token, context)
 		}
 	}
-	return next.Position(), line, list, elseList
+	if len(procs) == 1 {
+		return procs[0]
+	}
+	return newParallelNode(lt.pos, procs)
 }
 
 // If:
-//	{{if pipeline}} itemList {{end}}
-//	{{if pipeline}} itemList {{else}} itemList {{end}}
+//	if expr { choice }
+//	if expr { choice } else { choice }
+//	if expr { choice } else if ...
 // If keyword is past.
-func (t *Tree) ifControl() Node {
-	log.Println("ifControl()")
+func (t *Tree) ifControl(ifToken item) Node {
+	log.Println("ifControl(item)")
+
+	line := t.lex.lineNumber()
+	cond := t.expr()
+	list := t.block("if")
+	var elseList *ListNode
+	if t.peekNonSpace().typ == itemElse {
+		elseToken := t.nextNonSpace()
+		if next := t.peekNonSpace(); next.typ == itemIf {
+			t.nextNonSpace()
+			elseList = newListNode(elseToken.pos)
+			elseList.append(t.ifControl(next))
+		} else {
+			elseList = t.block("else")
+		}
+	}
+	return newIfNode(ifToken.pos, line, cond, list, elseList)
+}
+
+// block:
+//	'{' choice '}'
+func (t *Tree) block(context string) *ListNode {
+	log.Println("block(contextString)")
+
+	list := newListNode(t.expect(itemLeftCurlyBracket, context).pos)
+	list.append(t.choice())
+	t.expect(itemRightCurlyBracket, context)
+	return list
+}
 
-	return newIfNode(t.parseControl(true, "if"))
+// expr parses an expression, in which '+' is always addition.
+func (t *Tree) expr() Node {
+	log.Println("expr()")
+
+	return t.binaryExpr(1, false)
+}
+
+// sendArg parses the unparenthesized value of a send such as a!x+1, where a
+// '+' may instead start the next branch of a choice; see isChoicePlus.
+func (t *Tree) sendArg() Node {
+	log.Println("sendArg()")
+
+	return t.binaryExpr(1, true)
 }
 
-// End:
-//	{{end}}
-// End keyword is past.
-func (t *Tree) endControl() Node {
-	log.Println("endControl()")
+// binaryExpr parses a sequence of binary operations whose operators bind at
+// least as tightly as prec, grouping them by precedence. If inSend is set, a
+// '+' that starts another branch of a choice ends the expression.
+func (t *Tree) binaryExpr(prec int, inSend bool) Node {
+	log.Println("binaryExpr(prec, inSend)")
 
-	return newEndNode(t.expect(itemRightDelim, "end").pos)
+	x := t.unaryExpr()
+	for {
+		token := t.peekNonSpace()
+		op := binaryOp(token)
+		opPrec := precedence(op)
+		if opPrec < prec || inSend && token.typ == itemPlus && t.isChoicePlus() {
+			return x
+		}
+		t.nextNonSpace()
+		x = newBinaryExprNode(x.Position(), op, x, t.binaryExpr(opPrec+1, inSend))
+	}
 }
 
-// Else:
-//	{{else}}
-// Else keyword is past.
-func (t *Tree) elseControl() Node {
-	log.Println("elseContro()")
+// binaryOp returns the binary operator spelled by the token, or "" if the
+// token is not one. Note that '||' is the parallel bar outside expressions.
+func binaryOp(token item) string {
+	switch token.typ {
+	case itemParallel, itemLogicAND, itemEqual, itemNotEqual, itemLeftAngleBracket,
+		itemLessOrEqual, itemRightAngleBracket, itemGreaterOrEqual, itemPlus, itemMinus,
+		itemMultiply, itemDivide, itemModulo:
+		return token.val
+	}
+	return ""
+}
+
+// isChoicePlus reports whether the pending '+' after the value of a send
+// starts another branch of a choice rather than an addition. It does when
+// the token after it can only start a process: an action such as b?t or
+// b!x, a process invocation '<', 'if', 'nil' or '('. Thus a!x+y.P sends x+y,
+// while a!x+b?t.P chooses between a!x and b?t.P; parenthesize the whole
+// value, as in a!(x+(y-1)), to add a parenthesized expression.
+func (t *Tree) isChoicePlus() bool {
+	log.Println("isChoicePlus()")
+
+	plus := t.nextNonSpace()
+	token := t.nextNonSpace()
+	switch {
+	case token.typ == itemIdentifier:
+		op := t.next()
+		t.backup3(plus, token)
+		return isActionOp(op)
+	case token.typ == itemLeftParen, token.typ == itemIf, token.typ == itemNil, token.typ == itemLeftAngleBracket:
+		t.backup2(plus)
+		return true
+	}
+	t.backup2(plus)
+	return false
+}
+
+// unaryExpr:
+//	['-' | '+' | '!']... operand
+func (t *Tree) unaryExpr() Node {
+	log.Println("unaryExpr()")
+
+	switch token := t.peekNonSpace(); token.typ {
+	case itemMinus, itemPlus, itemBang:
+		t.nextNonSpace()
+		return newUnaryExprNode(token.pos, token.val, t.unaryExpr())
+	}
+	return t.operand()
+}
 
-	// Special case for "else if".
-	peek := t.peekNonSpace()
-	if peek.typ == itemIf {
-		// We see "{{else if ... " but in effect rewrite it to {{else}}{{if ... ".
-		return newElseNode(peek.pos, t.lex.lineNumber())
+// operand:
+//	identifier
+//	identifier '(' [expr [',' expr]...] ')'
+//	number
+//	string
+//	bool
+//	'(' expr ')'
+func (t *Tree) operand() Node {
+	log.Println("operand()")
+
+	switch token := t.nextNonSpace(); token.typ {
+	case itemIdentifier:
+		id := newIdentifierNode(token.val).SetPos(token.pos)
+		if t.peek().typ == itemLeftParen {
+			t.next()
+			if !t.hasFunction(token.val) {
+				t.reportAt(token.pos, token.pos+Pos(len(token.val)), "function %q not defined", token.val)
+			}
+			return newCallExprNode(token.pos, id, t.exprList("function call"))
+		}
+		return id
+	case itemNumber, itemCharConstant:
+		number, err := newNumberNode(token.pos, token.val, token.typ)
+		if err != nil {
+			t.error(err)
+		}
+		return number
+	case itemString, itemRawString:
+		s, err := strconv.Unquote(token.val)
+		if err != nil {
+			t.error(err)
+		}
+		return newStringNode(token.pos, token.val, s)
+	case itemBool:
+		return newBoolNode(token.pos, token.val == "true")
+	case itemLeftParen:
+		n := t.expr()
+		t.expect(itemRightParen, "parenthesized expression")
+		return n
+	default:
+		t.unexpected(token, "operand")
+	}
+	return nil
+}
+
+// exprList:
+//	[expr [',' expr]...] ')'
+// The left paren is past.
+func (t *Tree) exprList(context string) (list []Node) {
+	log.Println("exprList(contextString)")
+
+	if t.peekNonSpace().typ == itemRightParen {
+		t.nextNonSpace()
+		return
+	}
+	for {
+		list = append(list, t.expr())
+		token := t.nextNonSpace()
+		if token.typ == itemRightParen {
+			return
+		}
+		if token.typ != itemComma {
+			t.unexpected(token, context)
+		}
+	}
+}
+
+// identList:
+//	[identifier [',' identifier]...] ')'
+// The left paren is past.
+func (t *Tree) identList(context string) (list []*IdentifierNode) {
+	log.Println("identList(contextString)")
+
+	if t.peekNonSpace().typ == itemRightParen {
+		t.nextNonSpace()
+		return
+	}
+	for {
+		id := t.expect(itemIdentifier, context)
+		list = append(list, newIdentifierNode(id.val).SetPos(id.pos))
+		token := t.nextNonSpace()
+		if token.typ == itemRightParen {
+			return
+		}
+		if token.typ != itemComma {
+			t.unexpected(token, context)
+		}
 	}
-	return newElseNode(t.expect(itemRightDelim, "else").pos, t.lex.lineNumber())
 }
 
 // hasFunction reports if a function name exists in the Tree's maps.
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		// A '+' after the value of a send adds, unless a process follows it.
+		{"P = a!x+y.<P>", "a!(x+y).<P>"},
+		{"P = a!x+b?t.<P>", "(a!x + b?t.<P>)"},
+		{"P = a!(x+(y-1)).<P>", "a!(x+(y-1)).<P>"},
+		{"P = a!x*y-1+b!1.<P>", "(a!((x*y)-1) + b!1.<P>)"},
+		// && binds tighter than ||, and comparisons tighter than &&.
+		{"P(x,y) = if x > 0 && x==max(x,y) || goo(x+1, y) { a!1 }", "if (((x > 0) && (x == max(x,y))) || goo((x+1),y)) {a!1}"},
+		{"P(x,y) = if x < y || -x+1 >= y*2 { a!1 }", "if ((x < y) || ((-x+1) >= (y*2))) {a!1}"},
+	}
+	// Only the names of the functions matter to the parser.
+	funcs := map[string]interface{}{
+		"max": func(x, y int) int { return x },
+		"goo": func(x, y int) bool { return true },
+	}
+	for _, test := range tests {
+		treeSet, err := Parse("test.tz", "%%\n"+test.src+"\n%%\n", "%%", "%%", builtins, funcs)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		def := treeSet["P"].root.Nodes[0].(*ProcDefNode)
+		if got := grouped(def.Body); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+// grouped formats n with each binary operation in parentheses.
+func grouped(n Node) string {
+	switch n := n.(type) {
+	case *BinaryExprNode:
+		op := n.Op
+		if precedence(op) < precedence("+") {
+			op = " " + op + " "
+		}
+		return "(" + grouped(n.X) + op + grouped(n.Y) + ")"
+	case *UnaryExprNode:
+		return n.Op + grouped(n.X)
+	case *CallExprNode:
+		return n.Fun.Ident + "(" + groupedList(n.Args, ",") + ")"
+	case *SendNode:
+		if len(n.Args) == 1 {
+			return n.Chan.Ident + "!" + grouped(n.Args[0])
+		}
+		return n.Chan.Ident + "!(" + groupedList(n.Args, ",") + ")"
+	case *PrefixNode:
+		return grouped(n.Left) + "." + grouped(n.Right)
+	case *ChoiceNode:
+		return "(" + groupedList(n.Branches, " + ") + ")"
+	case *IfNode:
+		return "if " + grouped(n.Cond) + " {" + grouped(n.List) + "}"
+	case *ListNode:
+		return groupedList(n.Nodes, "")
+	}
+	return n.String()
+}
+
+func groupedList(nodes []Node, sep string) string {
+	s := ""
+	for i, n := range nodes {
+		if i > 0 {
+			s += sep
+		}
+		s += grouped(n)
+	}
+	return s
+}
+
+func TestParseRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		errs []string
+	}{
+		{"valid", "%%\nP = a!1.<P>\n%%\n", nil},
+		{"each declaration", "%%\nP = a!.<P>\nQ = b?x.<Q\nR = c!1.<R>\nS = = d!1\n%%\n", []string{
+			`test.tz:2:7: unexpected "." in operand`,
+			`test.tz:4:1: unexpected R in process invocation`,
+			`test.tz:5:5: unexpected "=" in process`,
+		}},
+		{"unclosed", "%%\nP(x) = if x > 0 { a!1\nQ = b!1.<Q>\nchan c [\nR = c!1.<R>\nT = <R||>\n%%\n", []string{
+			`test.tz:3:1: unexpected Q in if`,
+			`test.tz:5:1: unexpected R in channel declaration`,
+			`test.tz:6:9: unexpected ">" in process invocation`,
+		}},
+		{"properties", "%%\nprop p = always\nP = a!1.<P>\nprop q = eventually a!(x)\n%%\n", []string{
+			`test.tz:3:1: unexpected P in formula: want an action such as P! or an operator`,
+			`test.tz:4:24: value x of action pattern must be a constant or _`,
+		}},
+		{"unclosed section", "%%\nP = a!1.<P>\n", []string{
+			`test.tz:1:1: unclosed process section`,
+		}},
+		{"unclosed section after an error", "%%\nP = a!.<P>\nQ = a!1.\n", []string{
+			`test.tz:1:1: unclosed process section`,
+			`test.tz:2:7: unexpected "." in operand`,
+		}},
+		{"go block", "@@\nfunc f( int {}\n@@\n%%\nP = a!.<P>\nR = c!1.<R>\n%%\n", []string{
+			`test.tz:2:13: in @@ block: missing ',' in parameter list`,
+			`test.tz:5:7: unexpected "." in operand`,
+		}},
+	}
+	for _, test := range tests {
+		_, err := Parse("test.tz", test.src, "%%", "%%", builtins)
+		var errs []string
+		if err != nil {
+			ds, ok := err.(Diagnostics)
+			if !ok {
+				t.Errorf("%s: error %v is not Diagnostics", test.name, err)
+				continue
+			}
+			for _, d := range ds {
+				errs = append(errs, d.Error())
+			}
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%s: got errors\n\t%q\nwant\n\t%q", test.name, errs, test.errs)
+		}
+	}
+}
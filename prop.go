@@ -0,0 +1,703 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A PropResult is the outcome of checking a property on an LTS.
+type PropResult struct {
+	Prop  *PropDeclNode
+	Holds bool
+	// A counterexample when the property does not hold: an infinite run
+	// made of the steps of Prefix followed by those of Cycle forever. A
+	// step of -1 stands for staying in a state with no transitions.
+	Prefix, Cycle []int
+	Complete      bool // whether the LTS is complete, so that Holds is a proof
+}
+
+// A formula is a temporal formula in negation normal form, in which not
+// only applies to atoms.
+type formula struct {
+	op   formulaOp
+	atom *ActionPatternNode // for fAtom
+	neg  bool               // for fAtom: the atom is negated
+	x, y *formula
+	key  string // a canonical form, to compare formulas
+}
+
+type formulaOp int
+
+const (
+	fTrue formulaOp = iota
+	fFalse
+	fAtom
+	fAnd
+	fOr
+	fNext
+	fUntil   // x until y
+	fRelease // x release y: y holds up to and including the first x, if any
+)
+
+// newFormula returns the formula op with the operands x and y.
+func newFormula(op formulaOp, x, y *formula) *formula {
+	f := &formula{op: op, x: x, y: y}
+	switch op {
+	case fTrue:
+		f.key = "true"
+	case fFalse:
+		f.key = "false"
+	default:
+		if y == nil {
+			f.key = fmt.Sprintf("%d(%s)", op, x.key)
+		} else {
+			f.key = fmt.Sprintf("%d(%s,%s)", op, x.key, y.key)
+		}
+	}
+	return f
+}
+
+// newAtom returns the atom a, negated if neg is set.
+func newAtom(a *ActionPatternNode, neg bool) *formula {
+	f := &formula{op: fAtom, atom: a, neg: neg, key: fmt.Sprintf("%p", a)}
+	if neg {
+		f.key = "!" + f.key
+	}
+	return f
+}
+
+// nnf returns the formula n, negated if neg is set, in negation normal form.
+func nnf(n Node, neg bool) *formula {
+	switch n := n.(type) {
+	case *BoolNode:
+		if n.True != neg {
+			return newFormula(fTrue, nil, nil)
+		}
+		return newFormula(fFalse, nil, nil)
+	case *ActionPatternNode:
+		return newAtom(n, neg)
+	case *TemporalNode:
+		switch n.Op {
+		case "not":
+			return nnf(n.X, !neg)
+		case "next":
+			return newFormula(fNext, nnf(n.X, neg), nil)
+		case "always":
+			if neg {
+				return newFormula(fUntil, newFormula(fTrue, nil, nil), nnf(n.X, true))
+			}
+			return newFormula(fRelease, newFormula(fFalse, nil, nil), nnf(n.X, false))
+		case "eventually":
+			if neg {
+				return newFormula(fRelease, newFormula(fFalse, nil, nil), nnf(n.X, true))
+			}
+			return newFormula(fUntil, newFormula(fTrue, nil, nil), nnf(n.X, false))
+		case "never":
+			return nnf(newTemporalNode(n.Pos, "always", newTemporalNode(n.Pos, "not", n.X, nil), nil), neg)
+		case "&&":
+			if neg {
+				return newFormula(fOr, nnf(n.X, true), nnf(n.Y, true))
+			}
+			return newFormula(fAnd, nnf(n.X, false), nnf(n.Y, false))
+		case "||":
+			if neg {
+				return newFormula(fAnd, nnf(n.X, true), nnf(n.Y, true))
+			}
+			return newFormula(fOr, nnf(n.X, false), nnf(n.Y, false))
+		case "->":
+			return nnf(newTemporalNode(n.Pos, "||", newTemporalNode(n.Pos, "not", n.X, nil), n.Y), neg)
+		case "until":
+			if neg {
+				return newFormula(fRelease, nnf(n.X, true), nnf(n.Y, true))
+			}
+			return newFormula(fUntil, nnf(n.X, false), nnf(n.Y, false))
+		case "unless":
+			// x unless y is y release (x || y).
+			or := newTemporalNode(n.Pos, "||", n.X, n.Y)
+			if neg {
+				return newFormula(fUntil, nnf(n.Y, true), nnf(or, true))
+			}
+			return newFormula(fRelease, nnf(n.Y, false), nnf(or, false))
+		}
+	}
+	panic(fmt.Sprintf("nnf: unexpected %s in formula", n))
+}
+
+// A tableauNode is a state of the Büchi automaton built from a formula
+// following Gerth, Peled, Vardi and Wolper: the formulas in old hold on
+// the action taken from it and those in next from the following one on.
+type tableauNode struct {
+	id       int
+	incoming map[int]bool // the nodes with a transition to this one; -1 for the start
+	old      map[string]*formula
+	next     map[string]*formula
+	pending  []*formula // the formulas left to expand
+}
+
+// tableau builds the generalized Büchi automaton accepting the runs that
+// satisfy f.
+type tableau struct {
+	nodes   []*tableauNode
+	untils  []*formula // the until formulas, each giving an acceptance set
+	seenKey map[string]bool
+}
+
+func newTableau(f *formula) *tableau {
+	tb := &tableau{seenKey: make(map[string]bool)}
+	tb.collectUntils(f)
+	tb.expand(&tableauNode{
+		incoming: map[int]bool{-1: true},
+		old:      make(map[string]*formula),
+		next:     make(map[string]*formula),
+		pending:  []*formula{f},
+	})
+	return tb
+}
+
+func (tb *tableau) collectUntils(f *formula) {
+	if f == nil || tb.seenKey[f.key] {
+		return
+	}
+	tb.seenKey[f.key] = true
+	if f.op == fUntil {
+		tb.untils = append(tb.untils, f)
+	}
+	tb.collectUntils(f.x)
+	tb.collectUntils(f.y)
+}
+
+// expand expands the pending formulas of n into nodes of the automaton.
+func (tb *tableau) expand(n *tableauNode) {
+	if len(n.pending) == 0 {
+		for _, m := range tb.nodes {
+			if sameFormulas(m.old, n.old) && sameFormulas(m.next, n.next) {
+				for id := range n.incoming {
+					m.incoming[id] = true
+				}
+				return
+			}
+		}
+		n.id = len(tb.nodes)
+		tb.nodes = append(tb.nodes, n)
+		var next []*formula
+		for _, f := range n.next {
+			next = append(next, f)
+		}
+		sort.Slice(next, func(i, j int) bool { return next[i].key < next[j].key })
+		tb.expand(&tableauNode{
+			incoming: map[int]bool{n.id: true},
+			old:      make(map[string]*formula),
+			next:     make(map[string]*formula),
+			pending:  next,
+		})
+		return
+	}
+	f := n.pending[len(n.pending)-1]
+	n.pending = n.pending[:len(n.pending)-1]
+	if _, ok := n.old[f.key]; ok {
+		tb.expand(n)
+		return
+	}
+	switch f.op {
+	case fFalse:
+		return
+	case fTrue:
+		n.old[f.key] = f
+		tb.expand(n)
+	case fAtom:
+		neg := newAtom(f.atom, !f.neg)
+		if _, ok := n.old[neg.key]; ok {
+			return
+		}
+		n.old[f.key] = f
+		tb.expand(n)
+	case fAnd:
+		n.old[f.key] = f
+		n.pending = append(n.pending, f.x, f.y)
+		tb.expand(n)
+	case fNext:
+		n.old[f.key] = f
+		n.next[f.x.key] = f.x
+		tb.expand(n)
+	case fOr, fUntil, fRelease:
+		n1, n2 := n.split(f), n.split(f)
+		switch f.op {
+		case fOr:
+			n1.pending = append(n1.pending, f.x)
+			n2.pending = append(n2.pending, f.y)
+		case fUntil:
+			n1.pending = append(n1.pending, f.x)
+			n1.next[f.key] = f
+			n2.pending = append(n2.pending, f.y)
+		case fRelease:
+			n1.pending = append(n1.pending, f.y)
+			n1.next[f.key] = f
+			n2.pending = append(n2.pending, f.x, f.y)
+		}
+		tb.expand(n1)
+		tb.expand(n2)
+	}
+}
+
+// split returns a copy of n with f added to old.
+func (n *tableauNode) split(f *formula) *tableauNode {
+	c := &tableauNode{
+		incoming: make(map[int]bool),
+		old:      map[string]*formula{f.key: f},
+		next:     make(map[string]*formula),
+		pending:  append([]*formula(nil), n.pending...),
+	}
+	for id := range n.incoming {
+		c.incoming[id] = true
+	}
+	for k, g := range n.old {
+		c.old[k] = g
+	}
+	for k, g := range n.next {
+		c.next[k] = g
+	}
+	return c
+}
+
+func sameFormulas(a, b map[string]*formula) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// accepting reports whether n belongs to the acceptance set of the until
+// formula u: either u is not promised or its goal is reached.
+func (n *tableauNode) accepting(u *formula) bool {
+	_, promised := n.old[u.key]
+	_, reached := n.old[u.y.key]
+	return !promised || reached
+}
+
+// A propStep is the label of a step of a run: an action, or staying in a
+// state with no transitions.
+type propStep struct {
+	action   *Action
+	deadlock bool // for staying: the state is deadlocked rather than done
+}
+
+// propChecker checks the properties of a model on an LTS.
+type propChecker struct {
+	in   *Interp
+	lts  *LTS
+	out  [][]int // the edges leaving each state; -1 for staying put
+	dead map[int]bool
+}
+
+// CheckProp checks the property p on the LTS l by looking for a run of l
+// satisfying the negation of its formula. The runs of l are its infinite
+// paths, a state with no transitions staying put forever, so every finite
+// run ending in a deadlock or a finished state is a run.
+func (in *Interp) CheckProp(l *LTS, p *PropDeclNode) (res *PropResult, err error) {
+	defer catch(&err)
+	c := &propChecker{in: in, lts: l, out: make([][]int, len(l.States)), dead: make(map[int]bool)}
+	for i, e := range l.Edges {
+		c.out[e.From] = append(c.out[e.From], i)
+	}
+	for _, s := range l.Deadlocks {
+		c.dead[s] = true
+	}
+	for s := range c.out {
+		// A state whose transitions were all left out of a truncated LTS
+		// is a dead end rather than a state the run stays in.
+		if len(c.out[s]) == 0 && (c.dead[s] || l.States[s].Done()) {
+			c.out[s] = []int{-1}
+		}
+	}
+	res = &PropResult{Prop: p, Complete: !l.Truncated}
+	tb := newTableau(nnf(p.Formula, true))
+	res.Prefix, res.Cycle, res.Holds = c.search(tb)
+	// Once in a state with no transitions, the run stays there.
+	for i, e := range res.Prefix {
+		if e < 0 {
+			res.Prefix, res.Cycle = res.Prefix[:i], []int{-1}
+			break
+		}
+	}
+	return res, nil
+}
+
+// step returns the label of taking the edge e from the state s.
+func (c *propChecker) step(s, e int) propStep {
+	if e < 0 {
+		return propStep{deadlock: c.dead[s]}
+	}
+	return propStep{action: &c.lts.Edges[e].Action}
+}
+
+// satisfies reports whether the literals of n hold on the step.
+func (c *propChecker) satisfies(n *tableauNode, step propStep) bool {
+	for _, f := range n.old {
+		if f.op == fAtom && c.matches(f.atom, step) == f.neg {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the pattern a matches the step.
+func (c *propChecker) matches(a *ActionPatternNode, step propStep) bool {
+	if step.action == nil {
+		return a.Op == "" && (a.Chan.Ident == "deadlock" && step.deadlock || a.Chan.Ident == "done" && !step.deadlock)
+	}
+	act := step.action
+	switch a.Op {
+	case "":
+		return a.Chan.Ident == "tau" && act.Kind == ActTau
+	case "!":
+		if act.Kind != ActTau && act.Kind != ActSend {
+			return false
+		}
+	case "?":
+		if act.Kind != ActTau && act.Kind != ActReceive {
+			return false
+		}
+	case "!!":
+		if act.Kind != ActAsyncSend {
+			return false
+		}
+	case "??":
+		if act.Kind != ActAsyncReceive {
+			return false
+		}
+	}
+	if act.Chan != a.Chan.Ident {
+		return false
+	}
+	if a.Args == nil {
+		return true
+	}
+	if len(a.Args) != len(act.Vals) {
+		return false
+	}
+	for i, arg := range a.Args {
+		if id, ok := arg.(*IdentifierNode); ok && id.Ident == "_" {
+			continue
+		}
+		if formatValue(c.in.ev.eval(arg, nil)) != formatValue(act.Vals[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// A productNode is a state of the product of the LTS and the automaton.
+type productNode struct {
+	state, node int
+	succ        []productEdge
+	index, low  int // for Tarjan's algorithm; index 0 means unvisited
+	onStack     bool
+	scc         int
+}
+
+type productEdge struct {
+	to   int // the product node
+	edge int // the edge of the LTS; -1 for staying put
+}
+
+// search looks for an accepting run of the product of the LTS and the
+// automaton tb. It returns the run as a prefix and a cycle of edges of the
+// LTS, and whether there is none.
+func (c *propChecker) search(tb *tableau) (prefix, cycle []int, holds bool) {
+	var nodes []*productNode
+	ids := make(map[[2]int]int)
+	succs := make([][]int, len(tb.nodes)) // the automaton's transitions
+	var initial []int
+	for _, n := range tb.nodes {
+		for from := range n.incoming {
+			if from < 0 {
+				initial = append(initial, n.id)
+			} else {
+				succs[from] = append(succs[from], n.id)
+			}
+		}
+	}
+	for _, s := range succs {
+		sort.Ints(s)
+	}
+	sort.Ints(initial)
+	var get func(state, node int) int
+	get = func(state, node int) int {
+		key := [2]int{state, node}
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		id := len(nodes)
+		ids[key] = id
+		pn := &productNode{state: state, node: node}
+		nodes = append(nodes, pn)
+		for _, e := range c.out[state] {
+			if !c.satisfies(tb.nodes[node], c.step(state, e)) {
+				continue
+			}
+			to := state
+			if e >= 0 {
+				to = c.lts.Edges[e].To
+			}
+			for _, m := range succs[node] {
+				pn.succ = append(pn.succ, productEdge{get(to, m), e})
+			}
+		}
+		return id
+	}
+	var roots []int
+	for _, n := range initial {
+		roots = append(roots, get(0, n))
+	}
+
+	// Find the strongly connected components with Tarjan's algorithm and
+	// take the first one that has a cycle meeting every acceptance set.
+	index, nscc := 0, 0
+	var stack []int
+	var found = -1
+	var strongConnect func(v int)
+	strongConnect = func(v int) {
+		index++
+		pv := nodes[v]
+		pv.index, pv.low = index, index
+		stack = append(stack, v)
+		pv.onStack = true
+		for _, pe := range pv.succ {
+			w := nodes[pe.to]
+			if w.index == 0 {
+				strongConnect(pe.to)
+				if w.low < pv.low {
+					pv.low = w.low
+				}
+			} else if w.onStack && w.index < pv.low {
+				pv.low = w.index
+			}
+		}
+		if pv.low != pv.index {
+			return
+		}
+		var members []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			nodes[w].onStack = false
+			nodes[w].scc = nscc
+			members = append(members, w)
+			if w == v {
+				break
+			}
+		}
+		if found < 0 && c.accepts(tb, nodes, members, nscc) {
+			found = nscc
+		}
+		nscc++
+	}
+	for _, r := range roots {
+		if nodes[r].index == 0 {
+			strongConnect(r)
+		}
+	}
+	if found < 0 {
+		return nil, nil, true
+	}
+	prefix, start := c.pathTo(nodes, roots, func(v int) bool { return nodes[v].scc == found }, -1)
+	cycle = c.cycleFrom(tb, nodes, start, found)
+	return prefix, cycle, false
+}
+
+// accepts reports whether the component scc, made of members, has a cycle
+// and meets every acceptance set of tb.
+func (c *propChecker) accepts(tb *tableau, nodes []*productNode, members []int, scc int) bool {
+	cyclic := len(members) > 1
+	if !cyclic {
+		for _, pe := range nodes[members[0]].succ {
+			if pe.to == members[0] {
+				cyclic = true
+			}
+		}
+	}
+	if !cyclic {
+		return false
+	}
+	for _, u := range tb.untils {
+		met := false
+		for _, v := range members {
+			if tb.nodes[nodes[v].node].accepting(u) {
+				met = true
+				break
+			}
+		}
+		if !met {
+			return false
+		}
+	}
+	return true
+}
+
+// pathTo returns the edges of the LTS along a shortest path from one of
+// the sources to a node for which goal holds, and that node. If scc is not
+// negative, the path stays within that component.
+func (c *propChecker) pathTo(nodes []*productNode, sources []int, goal func(int) bool, scc int) ([]int, int) {
+	type via struct{ from, edge int }
+	prev := make(map[int]via)
+	queue := append([]int(nil), sources...)
+	for _, s := range sources {
+		prev[s] = via{-1, 0}
+		if goal(s) {
+			return nil, s
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, pe := range nodes[v].succ {
+			if _, ok := prev[pe.to]; ok || scc >= 0 && nodes[pe.to].scc != scc {
+				continue
+			}
+			prev[pe.to] = via{v, pe.edge}
+			if goal(pe.to) {
+				var path []int
+				for w := pe.to; prev[w].from >= 0; w = prev[w].from {
+					path = append(path, prev[w].edge)
+				}
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path, pe.to
+			}
+			queue = append(queue, pe.to)
+		}
+	}
+	panic("pathTo: goal not reachable")
+}
+
+// cycleFrom returns the edges of a cycle within the component scc from
+// start back to it that meets every acceptance set of tb.
+func (c *propChecker) cycleFrom(tb *tableau, nodes []*productNode, start, scc int) []int {
+	var cycle []int
+	v := start
+	for _, u := range tb.untils {
+		if tb.nodes[nodes[v].node].accepting(u) {
+			continue
+		}
+		path, w := c.pathTo(nodes, []int{v}, func(w int) bool { return tb.nodes[nodes[w].node].accepting(u) }, scc)
+		cycle = append(cycle, path...)
+		v = w
+	}
+	return append(cycle, c.closeVia(nodes, v, start, scc)...)
+}
+
+// closeVia returns the edges of a path of at least one step from v to
+// start within the component scc.
+func (c *propChecker) closeVia(nodes []*productNode, v, start, scc int) []int {
+	for _, pe := range nodes[v].succ {
+		if nodes[pe.to].scc != scc {
+			continue
+		}
+		path, _ := c.pathTo(nodes, []int{pe.to}, func(w int) bool { return w == start }, scc)
+		return append([]int{pe.edge}, path...)
+	}
+	panic("closeVia: no cycle")
+}
+
+// WriteCounterexample writes the run refuting the property of res to w in
+// the format of a simulation, and to trace as JSON lines if it is not nil.
+// The steps of the cycle repeat forever; in the trace, the first of them is
+// marked as the start of the loop.
+func (in *Interp) WriteCounterexample(w, trace io.Writer, l *LTS, res *PropResult) error {
+	var enc *json.Encoder
+	if trace != nil {
+		enc = json.NewEncoder(trace)
+	}
+	step := 1
+	write := func(edges []int, loop bool) error {
+		for i, e := range edges {
+			tr, err := in.transitionOf(l, l.Edges[e])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%d\t%s\n", step, tr)
+			if enc != nil {
+				ts := in.traceStep(step, tr)
+				ts.Loop = loop && i == 0
+				if err := enc.Encode(ts); err != nil {
+					return err
+				}
+			}
+			step++
+		}
+		return nil
+	}
+	if err := write(res.Prefix, false); err != nil {
+		return err
+	}
+	if len(res.Cycle) > 0 && res.Cycle[0] < 0 {
+		// A state with no transitions: the run stays there.
+		last, end := 0, "done"
+		if len(res.Prefix) > 0 {
+			last = l.Edges[res.Prefix[len(res.Prefix)-1]].To
+		}
+		for _, d := range l.Deadlocks {
+			if d == last {
+				end = "deadlocked"
+			}
+		}
+		fmt.Fprintf(w, "\tthe system stays %s\n", end)
+		return nil
+	}
+	if err := write(res.Cycle, true); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\tsteps %d to %d repeat forever\n", step-len(res.Cycle), step-1)
+	return nil
+}
+
+// transitionOf returns the transition of the system behind the edge e of l.
+func (in *Interp) transitionOf(l *LTS, e Edge) (Transition, error) {
+	s := l.States[e.From]
+	trs, err := in.Transitions(s)
+	if err != nil {
+		return Transition{}, err
+	}
+	for _, tr := range trs {
+		if tr.Action.Kind != e.Action.Kind || tr.Action.Message() != e.Action.Message() {
+			continue
+		}
+		next, err := in.Apply(s, tr)
+		if err != nil {
+			return Transition{}, err
+		}
+		if in.StateKey(next) == l.Keys[e.To] {
+			return tr, nil
+		}
+	}
+	return Transition{}, fmt.Errorf("no transition from state %d to state %d", e.From, e.To)
+}
+
+// Props returns the properties declared in the model.
+func (in *Interp) Props() []*PropDeclNode {
+	var props []*PropDeclNode
+	for _, n := range in.ev.tree.root.Nodes {
+		if p, ok := n.(*PropDeclNode); ok {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// propNames lists the names of props, for error messages.
+func propNames(props []*PropDeclNode) string {
+	names := make([]string, len(props))
+	for i, p := range props {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
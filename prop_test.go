@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+const propModel = `%%
+Ping = a!1.<Ping>
+Once = a!1.nil
+Sink = a?x.<Sink>
+Loop = <Ping||Sink>
+Stuck = <Once||Sink>
+prop live = always eventually a!
+prop alive = never deadlock
+prop first = a!(1)
+prop wrong = a!(2)
+prop again = always (a! -> next a!)
+%%
+`
+
+func TestCheckProp(t *testing.T) {
+	tests := []struct {
+		root, prop string
+		holds      bool
+	}{
+		{"Loop", "live", true},
+		{"Loop", "alive", true},
+		{"Loop", "first", true},
+		{"Loop", "wrong", false},
+		{"Loop", "again", true},
+		{"Stuck", "live", false},
+		{"Stuck", "alive", false},
+		{"Stuck", "first", true},
+		{"Stuck", "wrong", false},
+		{"Stuck", "again", false},
+	}
+	in := testInterp(t, propModel)
+	props := make(map[string]*PropDeclNode)
+	for _, p := range in.Props() {
+		props[p.Name] = p
+	}
+	for _, test := range tests {
+		l, err := in.Explore(test.root, nil, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", test.root, err)
+		}
+		res, err := in.CheckProp(l, props[test.prop])
+		if err != nil {
+			t.Fatalf("%s on %s: %v", test.prop, test.root, err)
+		}
+		if res.Holds != test.holds || !res.Complete {
+			t.Errorf("%s on %s: holds %v, complete %v; want holds %v", test.prop, test.root, res.Holds, res.Complete, test.holds)
+		}
+		if !res.Holds && len(res.Prefix)+len(res.Cycle) == 0 {
+			t.Errorf("%s on %s: no counterexample", test.prop, test.root)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replName is the name of the model built in the REPL, in positions.
+const replName = "repl"
+
+// The names of the processes the REPL defines for its own use.
+const (
+	replSim  = "__sim"
+	replEval = "__eval"
+)
+
+// declPattern matches the lines that declare something rather than hold an
+// expression: chan and prop declarations and process definitions, P = ...
+// or P(x,y) = ... but not P(x) == y.
+var declPattern = regexp.MustCompile(`^\s*(chan\s|prop\s|[\pL_][\pL\pN_]*\s*(\([^)]*\))?\s*=($|[^=]))`)
+
+// A repl is an interactive session in which a model is defined one
+// declaration at a time and its processes are simulated step by step.
+type repl struct {
+	out     io.Writer
+	lines   *bufio.Scanner
+	helpers []string       // the @@ blocks, in the order entered
+	decls   []string       // the declarations, in the order entered
+	keys    map[string]int // the index in decls of each declaration, by kind and name
+	sim     *Interp        // the interpreter of the simulation, which keeps its model
+	term    string         // the process term simulated
+	history []*State       // the states of the simulation, the current one last
+	trs     []Transition   // the transitions enabled in the current state
+	rand    *rand.Rand     // the scheduler of :run
+	cmds    map[string]func(args string) error
+}
+
+// Repl runs a REPL reading from r and writing to w until the input ends or
+// :quit is entered. The model starts with the declarations of files.
+func Repl(r io.Reader, w io.Writer, files ...string) error {
+	rp := &repl{
+		out:   w,
+		lines: bufio.NewScanner(r),
+		keys:  make(map[string]int),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	rp.cmds = map[string]func(string) error{
+		"help":  rp.help,
+		"list":  rp.list,
+		"load":  rp.load,
+		"reset": rp.reset,
+		"eval":  rp.eval,
+		"sim":   rp.simulate,
+		"trans": func(string) error { return rp.transitions() },
+		"step":  rp.step,
+		"run":   rp.run,
+		"back":  rp.back,
+		"state": rp.state,
+		"chans": rp.chans,
+	}
+	for _, file := range files {
+		if err := rp.load(file); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "tozzy REPL: enter declarations, expressions or commands; :help lists them.\n")
+	for {
+		line, ok := rp.read("tozzy> ")
+		if !ok {
+			fmt.Fprintln(w)
+			return rp.lines.Err()
+		}
+		line = strings.TrimSpace(line)
+		if line == ":quit" || line == ":q" {
+			return nil
+		}
+		if err := rp.do(line); err != nil {
+			fmt.Fprintln(w, err)
+		}
+	}
+}
+
+// read prompts for a line and reads it.
+func (rp *repl) read(prompt string) (string, bool) {
+	fmt.Fprint(rp.out, prompt)
+	if !rp.lines.Scan() {
+		return "", false
+	}
+	return rp.lines.Text(), true
+}
+
+// do runs a line of input.
+func (rp *repl) do(line string) error {
+	switch {
+	case line == "" || strings.HasPrefix(line, "//"):
+		return nil
+	case strings.HasPrefix(line, ":"):
+		name, args := line[1:], ""
+		if i := strings.IndexAny(name, " \t"); i >= 0 {
+			name, args = name[:i], strings.TrimSpace(name[i:])
+		}
+		cmd := rp.cmds[name]
+		if cmd == nil {
+			return fmt.Errorf("unknown command :%s; :help lists the commands", name)
+		}
+		return cmd(args)
+	case line == goCodeMarker:
+		return rp.helper()
+	case declPattern.MatchString(line):
+		return rp.declare(line)
+	}
+	return rp.eval(line)
+}
+
+func (rp *repl) help(string) error {
+	fmt.Fprint(rp.out, `Declarations, as in a model, add to the model or replace the one of the
+same name:
+	P(x) = a!x.<P(x+1)>
+	chan aa [2]
+	prop live = always eventually a!
+	@@ ... @@              Go helpers, over several lines
+An unfinished declaration continues on the next line. An expression, such
+as max(1,2) == 2, is evaluated.
+Commands:
+	:eval expr             evaluate an expression
+	:list                  print the model
+	:load file             add the declarations of a file
+	:reset                 forget the model and the simulation
+	:sim term              start simulating a process term, such as <P(1)||Q>
+	:trans                 list the transitions enabled
+	:step [n]              take the transition n, or the only one enabled
+	:run [n]               take up to n random steps, 10 by default
+	:back                  undo the last step
+	:state                 show the processes of the simulation
+	:chans                 show the buffers of the asynchronous channels
+	:quit                  leave
+`)
+	return nil
+}
+
+// text returns the source of a model of the helpers and declarations.
+func (rp *repl) text(helpers, decls []string) string {
+	var b strings.Builder
+	for _, h := range helpers {
+		b.WriteString(h + "\n")
+	}
+	b.WriteString("%%\n")
+	for _, d := range decls {
+		b.WriteString(d + "\n")
+	}
+	b.WriteString("%%\n")
+	return b.String()
+}
+
+// incomplete reports whether the parse error err comes from input ending
+// too early.
+func incomplete(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, `unexpected "%%"`) || strings.Contains(msg, "unclosed")
+}
+
+// declare adds the declaration starting with line to the model, reading
+// more lines while it is unfinished.
+func (rp *repl) declare(line string) error {
+	text := line
+	for {
+		_, err := Parse(replName, rp.text(rp.helpers, []string{text}), "%%", "%%", builtins)
+		if err == nil {
+			break
+		}
+		if !incomplete(err) {
+			return err
+		}
+		more, ok := rp.read("...... ")
+		if !ok || strings.TrimSpace(more) == "" {
+			return err
+		}
+		text += "\n" + more
+	}
+	treeSet, _ := Parse(replName, rp.text(rp.helpers, []string{text}), "%%", "%%", builtins)
+	decls := append([]string(nil), rp.decls...)
+	var added []string
+	for _, n := range treeSet[fileKey(replName)].root.Nodes {
+		key := declKey(n)
+		if key == "" {
+			continue
+		}
+		if i, ok := rp.keys[key]; ok {
+			decls[i] = n.String()
+			fmt.Fprintf(rp.out, "redefined %s\n", key)
+		} else {
+			rp.keys[key] = len(decls)
+			decls = append(decls, n.String())
+		}
+		added = append(added, key)
+	}
+	if err := rp.update(rp.helpers, decls); err != nil {
+		for _, key := range added {
+			if rp.keys[key] >= len(rp.decls) {
+				delete(rp.keys, key)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// declKey returns the kind and name of what the node n declares.
+func declKey(n Node) string {
+	switch n := n.(type) {
+	case *ProcDefNode:
+		return "process " + n.Name
+	case *ChanDeclNode:
+		return "channel " + n.Name.Ident
+	case *PropDeclNode:
+		return "property " + n.Name
+	}
+	return ""
+}
+
+// helper reads a block of Go helpers up to the closing marker and adds it
+// to the model.
+func (rp *repl) helper() error {
+	var b strings.Builder
+	for {
+		line, ok := rp.read("@@.... ")
+		if !ok {
+			return fmt.Errorf("unclosed %s block", goCodeMarker)
+		}
+		if strings.TrimSpace(line) == goCodeMarker {
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	block := goCodeMarker + "\n" + b.String() + goCodeMarker
+	return rp.update(append(rp.helpers[:len(rp.helpers):len(rp.helpers)], block), rp.decls)
+}
+
+// update makes the model that of helpers and decls if it parses. The errors
+// Check finds are printed but do not stop the update, since a model being
+// entered may call processes not defined yet.
+func (rp *repl) update(helpers, decls []string) error {
+	treeSet, err := Parse(replName, rp.text(helpers, decls), "%%", "%%", builtins)
+	if err != nil {
+		return err
+	}
+	rp.helpers, rp.decls = helpers, decls
+	_, err = Check(treeSet, replName)
+	if errs, ok := err.(Diagnostics); ok {
+		for _, e := range errs {
+			fmt.Fprintf(rp.out, "note: %s\n", e.Msg)
+		}
+	} else if err != nil {
+		return err
+	}
+	_, err = NewInterp(treeSet, replName, builtins)
+	return err
+}
+
+func (rp *repl) list(string) error {
+	for _, h := range rp.helpers {
+		fmt.Fprintln(rp.out, h)
+	}
+	for _, d := range rp.decls {
+		fmt.Fprintln(rp.out, d)
+	}
+	return nil
+}
+
+// load adds the helpers and declarations of the named file to the model.
+func (rp *repl) load(name string) error {
+	text, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	treeSet, err := Parse(name, string(text), "%%", "%%", builtins)
+	if err != nil {
+		return err
+	}
+	helpers := append([]string(nil), rp.helpers...)
+	decls := append([]string(nil), rp.decls...)
+	keys := make(map[string]int)
+	for k, v := range rp.keys {
+		keys[k] = v
+	}
+	for _, n := range treeSet[fileKey(name)].root.Nodes {
+		if g, ok := n.(*GoBlockNode); ok {
+			helpers = append(helpers, g.String())
+			continue
+		}
+		key := declKey(n)
+		if i, ok := keys[key]; ok {
+			decls[i] = n.String()
+		} else if key != "" {
+			keys[key] = len(decls)
+			decls = append(decls, n.String())
+		}
+	}
+	if err := rp.update(helpers, decls); err != nil {
+		return err
+	}
+	rp.keys = keys
+	fmt.Fprintf(rp.out, "loaded %s: %d declarations\n", name, len(rp.decls))
+	return nil
+}
+
+func (rp *repl) reset(string) error {
+	rp.helpers, rp.decls, rp.keys = nil, nil, make(map[string]int)
+	rp.sim, rp.history, rp.trs = nil, nil, nil
+	return nil
+}
+
+// withModel returns an interpreter of the model with the declarations
+// extra added.
+func (rp *repl) withModel(extra string) (*Interp, error) {
+	treeSet, err := Parse(replName, rp.text(rp.helpers, append(rp.decls[:len(rp.decls):len(rp.decls)], extra)), "%%", "%%", builtins)
+	if err != nil {
+		return nil, err
+	}
+	return NewInterp(treeSet, replName, builtins)
+}
+
+// eval evaluates an expression in the model, as the argument of a send.
+func (rp *repl) eval(expr string) (err error) {
+	if expr == "" {
+		return fmt.Errorf("usage: :eval expr")
+	}
+	in, err := rp.withModel(fmt.Sprintf("%s = %s!(%s)", replEval, replEval, expr))
+	if err != nil {
+		return err
+	}
+	defer catch(&err)
+	for _, n := range in.ev.tree.root.Nodes {
+		if def, ok := n.(*ProcDefNode); ok && def.Name == replEval {
+			send := def.Body.(*SendNode)
+			vals := make([]Value, len(send.Args))
+			for i, arg := range send.Args {
+				vals[i] = in.ev.eval(arg, nil)
+			}
+			if len(vals) == 1 {
+				fmt.Fprintln(rp.out, formatValue(vals[0]))
+			} else {
+				fmt.Fprintln(rp.out, formatValues(vals))
+			}
+		}
+	}
+	return nil
+}
+
+// simulate starts the simulation of a process term.
+func (rp *repl) simulate(term string) error {
+	if term == "" {
+		return fmt.Errorf("usage: :sim term")
+	}
+	in, err := rp.withModel(fmt.Sprintf("%s = %s", replSim, term))
+	if err != nil {
+		return err
+	}
+	s, err := in.Start(replSim, nil)
+	if err != nil {
+		return err
+	}
+	rp.sim, rp.term, rp.history = in, term, []*State{s}
+	return rp.transitions()
+}
+
+// current returns the current state of the simulation.
+func (rp *repl) current() (*State, error) {
+	if len(rp.history) == 0 {
+		return nil, fmt.Errorf("no simulation; start one with :sim term")
+	}
+	return rp.history[len(rp.history)-1], nil
+}
+
+// transitions lists the transitions enabled in the current state, or why
+// there are none.
+func (rp *repl) transitions() error {
+	s, err := rp.current()
+	if err != nil {
+		return err
+	}
+	if rp.trs, err = rp.sim.Transitions(s); err != nil {
+		return err
+	}
+	switch {
+	case s.Done():
+		fmt.Fprintf(rp.out, "%s is done after %d steps\n", rp.term, len(rp.history)-1)
+	case len(rp.trs) == 0:
+		fmt.Fprintf(rp.out, "%s deadlocked after %d steps; blocked:\n", rp.term, len(rp.history)-1)
+		return rp.sim.reportDeadlock(rp.out, s)
+	}
+	for i, tr := range rp.trs {
+		fmt.Fprintf(rp.out, "  [%d] %s\n", i+1, tr)
+	}
+	return nil
+}
+
+// step takes the transition numbered arg, or the only one enabled.
+func (rp *repl) step(arg string) error {
+	if _, err := rp.current(); err != nil {
+		return err
+	}
+	i := 0
+	switch {
+	case arg != "":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(rp.trs) {
+			return fmt.Errorf("no transition %q; :trans lists them", arg)
+		}
+		i = n - 1
+	case len(rp.trs) == 0:
+		return fmt.Errorf("no transition enabled")
+	case len(rp.trs) > 1:
+		return fmt.Errorf("%d transitions enabled; pick one with :step n", len(rp.trs))
+	}
+	return rp.take(i)
+}
+
+// take takes the transition i of the current state.
+func (rp *repl) take(i int) error {
+	s, _ := rp.current()
+	tr := rp.trs[i]
+	next, err := rp.sim.Apply(s, tr)
+	if err != nil {
+		return err
+	}
+	rp.history = append(rp.history, next)
+	fmt.Fprintf(rp.out, "%d\t%s\n", len(rp.history)-1, tr)
+	return rp.transitions()
+}
+
+// run takes up to arg steps picked at random, 10 by default.
+func (rp *repl) run(arg string) error {
+	if _, err := rp.current(); err != nil {
+		return err
+	}
+	n := 10
+	if arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+			return fmt.Errorf("bad number of steps %q", arg)
+		}
+	}
+	for ; n > 0 && len(rp.trs) > 0; n-- {
+		s, _ := rp.current()
+		tr := rp.trs[rp.rand.Intn(len(rp.trs))]
+		next, err := rp.sim.Apply(s, tr)
+		if err != nil {
+			return err
+		}
+		rp.history = append(rp.history, next)
+		fmt.Fprintf(rp.out, "%d\t%s\n", len(rp.history)-1, tr)
+		if rp.trs, err = rp.sim.Transitions(next); err != nil {
+			return err
+		}
+	}
+	return rp.transitions()
+}
+
+// back undoes the last step.
+func (rp *repl) back(string) error {
+	if len(rp.history) < 2 {
+		return fmt.Errorf("no step to undo")
+	}
+	rp.history = rp.history[:len(rp.history)-1]
+	return rp.transitions()
+}
+
+// state shows what each process of the simulation runs.
+func (rp *repl) state(string) error {
+	s, err := rp.current()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(rp.out, "%s after %d steps:\n", rp.term, len(rp.history)-1)
+	rp.sim.leaves(s.root, nil, func(t *term, path []int) {
+		who := (&offer{proc: t.proc, path: path}).who()
+		fmt.Fprintf(rp.out, "\t%s: %s\n", who, t.node)
+	})
+	return rp.chans("")
+}
+
+// chans shows the messages in the buffers of the asynchronous channels.
+func (rp *repl) chans(string) error {
+	s, err := rp.current()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range rp.sim.chans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msgs := make([]string, len(s.queues[name]))
+		for i, msg := range s.queues[name] {
+			msgs[i] = formatValues(msg)
+		}
+		fmt.Fprintf(rp.out, "\t%s: [%s] %d of %d\n", name, strings.Join(msgs, " "), len(msgs), capacity(rp.sim.chans[name]))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// A Scheduler picks the transition a simulation takes among those enabled,
+// deciding both which branch of a choice is taken and how parallel
+// processes interleave.
+type Scheduler interface {
+	// Pick returns the index in trs, which is never empty, of the
+	// transition to take.
+	Pick(trs []Transition) int
+}
+
+// NewScheduler returns the scheduler called name: "random", which picks
+// at random from a source seeded with seed, "rr", which lets the processes
+// take turns, or "first", which always takes the first transition enabled.
+// The random and first schedulers make the same picks for the same model
+// on every machine.
+func NewScheduler(name string, seed int64) (Scheduler, error) {
+	switch name {
+	case "random":
+		return &randomScheduler{rand.New(rand.NewSource(seed))}, nil
+	case "rr":
+		return &roundRobinScheduler{}, nil
+	case "first":
+		return firstScheduler{}, nil
+	}
+	return nil, fmt.Errorf("unknown scheduler %q: want random, rr or first", name)
+}
+
+// randomScheduler picks a transition at random.
+type randomScheduler struct {
+	rand *rand.Rand
+}
+
+func (s *randomScheduler) Pick(trs []Transition) int {
+	return s.rand.Intn(len(trs))
+}
+
+// roundRobinScheduler picks the first transition of the process that comes
+// next after the one picked last, in the order of the processes' positions
+// among the parallel components. A handshake counts as a step of its sender.
+type roundRobinScheduler struct {
+	last []int // the position of the process picked last; nil at first
+}
+
+func (s *roundRobinScheduler) Pick(trs []Transition) int {
+	pick, wrap := -1, 0
+	for i, tr := range trs {
+		path := tr.offers[0].path
+		if s.last != nil && comparePaths(path, s.last) > 0 && (pick < 0 || comparePaths(path, trs[pick].offers[0].path) < 0) {
+			pick = i
+		}
+		if comparePaths(path, trs[wrap].offers[0].path) < 0 {
+			wrap = i
+		}
+	}
+	if pick < 0 {
+		pick = wrap
+	}
+	s.last = trs[pick].offers[0].path
+	return pick
+}
+
+// comparePaths compares the positions p and q of two processes among the
+// parallel components, returning -1, 0 or +1.
+func comparePaths(p, q []int) int {
+	for i := 0; i < len(p) && i < len(q); i++ {
+		switch {
+		case p[i] < q[i]:
+			return -1
+		case p[i] > q[i]:
+			return +1
+		}
+	}
+	switch {
+	case len(p) < len(q):
+		return -1
+	case len(p) > len(q):
+		return +1
+	}
+	return 0
+}
+
+// firstScheduler picks the first transition.
+type firstScheduler struct{}
+
+func (firstScheduler) Pick(trs []Transition) int {
+	return 0
+}
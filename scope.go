@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A scope is a variable in scope, linked to the ones declared before it.
+type scope struct {
+	decl    *IdentifierNode   // the parameter or received variable
+	also    []*IdentifierNode // the variables it stands for on other branches
+	kind    RefKind
+	partial bool // whether only some branches bind it, so it is out of scope
+	outer   *scope
+}
+
+// lookup returns the innermost variable called name, or nil.
+func (s *scope) lookup(name string) *scope {
+	for ; s != nil; s = s.outer {
+		if s.decl.Ident == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// A resolver holds the state of the scope analysis of a model.
+type resolver struct {
+	c        *checker
+	helpers  map[string]bool
+	chans    map[string]*IdentifierNode // the identifier declaring each channel
+	bindings []*IdentifierNode          // the variables bound, in the order found
+	owner    map[*IdentifierNode]string // the process of each parameter
+	used     map[*IdentifierNode]bool
+}
+
+// resolve resolves every identifier of the processes defs and the
+// properties props: a variable refers to a parameter of its process or to a
+// variable received before it, a function to a @@ helper or a builtin and
+// a channel to its declaration or else its first use. It annotates each
+// IdentifierNode with what it refers to. Variables used out of scope are
+// errors; variables that shadow another and bindings never used are
+// warnings. A receive binds its variables in what follows it only, so in
+// (a!x + b?t).c!t the t of c!t is out of scope after a!x.
+func (c *checker) resolve(defs []*ProcDefNode, props []*PropDeclNode) {
+	r := &resolver{
+		c:       c,
+		helpers: make(map[string]bool),
+		chans:   make(map[string]*IdentifierNode),
+		owner:   make(map[*IdentifierNode]string),
+		used:    make(map[*IdentifierNode]bool),
+	}
+	for _, n := range c.tree.root.Nodes {
+		switch n := n.(type) {
+		case *GoBlockNode:
+			for _, fn := range n.Funcs {
+				r.helpers[fn.Name.Name] = true
+			}
+		case *ChanDeclNode:
+			r.channel(n.Name)
+		}
+	}
+	for _, def := range defs {
+		var s *scope
+		for _, p := range def.Params {
+			if prev := s.lookup(p.Ident); prev != nil {
+				r.errorf(p, "duplicate parameter %s of process %s", p, def.Name)
+			}
+			p.Ref, p.Decl = RefParam, p
+			r.owner[p] = def.Name
+			r.bindings = append(r.bindings, p)
+			s = &scope{decl: p, kind: RefParam, outer: s}
+		}
+		r.proc(def.Body, s)
+	}
+	for _, p := range props {
+		Inspect(p.Formula, func(n Node) bool {
+			if a, ok := n.(*ActionPatternNode); ok && a.Op != "" {
+				r.channel(a.Chan)
+			}
+			return true
+		})
+	}
+	for _, b := range r.bindings {
+		switch {
+		case r.used[b] || b.Ident == "_":
+		case b.Ref == RefParam:
+			r.warnf(b, "parameter %s of process %s is not used", b, r.owner[b])
+		default:
+			r.warnf(b, "%s is received but not used", b)
+		}
+	}
+}
+
+// errorf reports an error at the node and returns it.
+func (r *resolver) errorf(n Node, format string, args ...interface{}) *Diagnostic {
+	return r.c.errorf(n, "%s", fmt.Sprintf(format, args...))
+}
+
+// warnf reports a warning at the node and returns it.
+func (r *resolver) warnf(n Node, format string, args ...interface{}) *Diagnostic {
+	return r.c.warnf(n, "%s", fmt.Sprintf(format, args...))
+}
+
+// channel resolves the channel ch.
+func (r *resolver) channel(ch *IdentifierNode) {
+	decl := r.chans[ch.Ident]
+	if decl == nil {
+		decl = ch
+		r.chans[ch.Ident] = ch
+	}
+	ch.Ref, ch.Decl = RefChan, decl
+}
+
+// proc resolves the identifiers of the process n, with the variables of s
+// in scope, and returns the scope of what runs after it. Each process is
+// resolved once: what follows a choice or an if sees the variables bound
+// on every branch of it.
+func (r *resolver) proc(n Node, s *scope) *scope {
+	switch n := n.(type) {
+	case *SendNode:
+		r.channel(n.Chan)
+		r.exprs(n.Args, s)
+	case *AsyncSendNode:
+		r.channel(n.Chan)
+		r.exprs(n.Args, s)
+	case *ReceiveNode:
+		r.channel(n.Chan)
+		return r.receive(n.Vars, s)
+	case *AsyncReceiveNode:
+		r.channel(n.Chan)
+		return r.receive(n.Vars, s)
+	case *PrefixNode:
+		return r.proc(n.Right, r.proc(n.Left, s))
+	case *ChoiceNode:
+		exits := make([]*scope, len(n.Branches))
+		for i, branch := range n.Branches {
+			exits[i] = r.proc(branch, s)
+		}
+		return merge(s, exits)
+	case *IfNode:
+		r.expr(n.Cond, s)
+		exits := []*scope{r.proc(n.List, s), s}
+		if n.ElseList != nil {
+			exits[1] = r.proc(n.ElseList, s)
+		}
+		return merge(s, exits)
+	case *ListNode:
+		for _, elem := range n.Nodes {
+			s = r.proc(elem, s)
+		}
+	case *ProcCallNode:
+		r.exprs(n.Args, s)
+	case *ParallelNode:
+		for _, call := range n.Procs {
+			r.exprs(call.Args, s)
+		}
+	}
+	return s
+}
+
+// merge returns the scope after the branches that start in s and end in
+// the scopes exits. A variable that every branch binds, or leaves bound,
+// stays in scope and stands for each of its declarations; one that only
+// some branches bind is kept, out of scope, so that a later receive of the
+// same name still shadows it.
+func merge(s *scope, exits []*scope) *scope {
+	if len(exits) == 1 {
+		return exits[0]
+	}
+	var names []string
+	found := make(map[string]bool)
+	for _, exit := range exits {
+		var bound []string
+		for e := exit; e != s; e = e.outer {
+			bound = append(bound, e.decl.Ident)
+		}
+		for i := len(bound) - 1; i >= 0; i-- {
+			if !found[bound[i]] {
+				found[bound[i]] = true
+				names = append(names, bound[i])
+			}
+		}
+	}
+	for _, name := range names {
+		m := &scope{outer: s}
+		var shadowed *scope // a declaration out of scope, if no branch binds name
+		for _, exit := range exits {
+			e := exit.lookup(name)
+			switch {
+			case e == nil || e.partial:
+				m.partial = true
+				if shadowed == nil {
+					shadowed = e
+				}
+			case m.decl == nil:
+				m.decl, m.kind = e.decl, e.kind
+				m.also = append(m.also, e.also...)
+			case e.decl != m.decl:
+				m.also = append(append(m.also, e.decl), e.also...)
+			}
+		}
+		if m.decl == nil {
+			m.decl, m.kind = shadowed.decl, shadowed.kind
+		}
+		s = m
+	}
+	return s
+}
+
+// receive returns s with the variables vars bound by a receive.
+func (r *resolver) receive(vars []*IdentifierNode, s *scope) *scope {
+	inner := s
+	for i, v := range vars {
+		if v.Ident == "_" {
+			continue
+		}
+		for _, w := range vars[:i] {
+			if w.Ident == v.Ident {
+				r.errorf(v, "%s received twice", v)
+			}
+		}
+		if prev := s.lookup(v.Ident); prev != nil {
+			what := "the variable received"
+			if prev.kind == RefParam {
+				what = "the parameter"
+			}
+			d := r.warnf(v, "%s shadows %s %s", v, what, v)
+			d.note(r.c.tree, prev.decl, "%s %s is declared here", what, v)
+		}
+		v.Ref, v.Decl = RefReceived, v
+		r.bindings = append(r.bindings, v)
+		inner = &scope{decl: v, kind: RefReceived, outer: inner}
+	}
+	return inner
+}
+
+func (r *resolver) exprs(nodes []Node, s *scope) {
+	for _, n := range nodes {
+		r.expr(n, s)
+	}
+}
+
+// expr resolves the identifiers of the expression n.
+func (r *resolver) expr(n Node, s *scope) {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		v := s.lookup(n.Ident)
+		if v == nil || v.partial {
+			r.errorf(n, "undefined: %s", n)
+			return
+		}
+		// Past a choice, the identifier refers to the variable of the
+		// first branch and uses those of all of them.
+		n.Ref, n.Decl = v.kind, v.decl
+		r.used[v.decl] = true
+		for _, d := range v.also {
+			r.used[d] = true
+		}
+	case *UnaryExprNode:
+		r.expr(n.X, s)
+	case *BinaryExprNode:
+		r.expr(n.X, s)
+		r.expr(n.Y, s)
+	case *CallExprNode:
+		n.Fun.Ref = RefBuiltin
+		if r.helpers[n.Fun.Ident] {
+			n.Fun.Ref = RefHelper
+		}
+		r.exprs(n.Args, s)
+	}
+}
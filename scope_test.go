@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	testChecks(t, []checkTest{
+		{"every branch", "%%\nP(x) = if x > 0 { a?y } else { b?y }.c!y.<P(y)>\n%%\n", nil},
+		{"one branch", "%%\nQ(x) = if x > 0 { a?y }.c!y.<Q(x)>\n%%\n", []string{
+			"test.tz:2:21: warning: y is received but not used",
+			"test.tz:2:27: undefined: y",
+		}},
+		{"choice", "%%\nR = (a!1 + b?t).c!t.<R>\n%%\n", []string{
+			"test.tz:2:14: warning: t is received but not used",
+			"test.tz:2:19: undefined: t",
+		}},
+		{"shadow", "%%\nR = (a?u + b!1).a?u.c!u.<R>\n%%\n", []string{
+			"test.tz:2:8: warning: u is received but not used",
+			"test.tz:2:19: warning: u shadows the variable received u",
+		}},
+	})
+}
@@ -1,134 +1,737 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func tozzy() {
-	fmt.Println("//=========== Welcome to tozzy world! ===========")
-	flagSet()
-	
-	readTozzyDesc()
+	os.Exit(run(os.Args[1:]))
 }
 
-func flagSet() { // DOTO - make them reality
-	flag.String("word", "foo", "a string")
-	flag.Int("numb", 42, "an int")
-	flag.Bool("fork", false, "a bool")
-	flag.Parse()
+// A command is a subcommand of tozzy, run as tozzy name [flags] args.
+type command struct {
+	name    string
+	args    string // the arguments after the flags, for the usage line
+	short   string // what the command does, in a line
+	long    string // the help text
+	minArgs int
+	maxArgs int                    // -1 for no limit
+	flags   func(fs *flag.FlagSet) // registers the flags of the command; nil for none
+	run     func(args []string) error
 }
 
-func readTozzyDesc() {
-	if !hasCmlArg() {
-		readAndEchoStdin()
-	} else {
-		parseTozzyFile()
-	}
+// An exitCode ends tozzy with the code without a message, the command having
+// reported the failure already.
+type exitCode int
+
+func (e exitCode) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
 }
 
-func hasCmlArg() bool {
-	if flag.Arg(0) == "" {
-		return false
-	}
+// The exit codes of tozzy.
+const (
+	exitOK      = 0 // the command succeeded
+	exitFailure = 1 // the model has errors or fails what was checked
+	exitUsage   = 2 // the command line is wrong
+)
 
-	return true
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "parse",
+			args:    "file",
+			short:   "print the declarations of a model as parsed",
+			long:    "Parse parses the model and prints its declarations one per line, without\nchecking them.",
+			minArgs: 1, maxArgs: 1,
+			run: runParse,
+		},
+		{
+			name:  "check",
+			args:  "file [process]",
+			short: "check a model and the properties of a process",
+			long: "Check checks the model for undefined names, wrong numbers of arguments or\n" +
+				"values, unguarded recursion and type conflicts, and prints every error\n" +
+				"found. Given a process, it also checks the properties the model declares\n" +
+				"on the state space of the process and prints a counterexample for each\n" +
+				"one that fails. With -json, it prints the errors and warnings as a JSON\n" +
+				"array instead, for editors, and checks no property.",
+			minArgs: 1, maxArgs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&checkJSON, "json", false, "print the errors and warnings as JSON")
+				fs.StringVar(&propName, "prop", "", "check only the `property` of that name")
+				fs.StringVar(&simTrace, "trace", "", "write the first counterexample to `file` as JSON lines")
+				exploreFlags(fs)
+			},
+			run: runCheck,
+		},
+		{
+			name:  "fmt",
+			args:  "file...",
+			short: "print models in the canonical layout",
+			long: "Fmt prints the models in the canonical layout of tozzy: one declaration\n" +
+				"per line, one choice branch per line with the + under the =, the bodies\n" +
+				"of if and else indented by four spaces and no space around . and ||.\n" +
+				"Comments are kept, and so is the text outside the process sections,\n" +
+				"the @@ blocks among it. Formatting a formatted model changes nothing.",
+			minArgs: 1, maxArgs: -1,
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&fmtList, "l", false, "list the files whose layout is not canonical instead of printing them")
+				fs.BoolVar(&fmtDiff, "d", false, "print the changes to the layout as diffs instead of the files")
+				fs.BoolVar(&fmtWrite, "w", false, "write the files back instead of printing them")
+			},
+			run: runFmt,
+		},
+		{
+			name:    "gen",
+			args:    "file process",
+			short:   "translate a model to Go",
+			long:    "Gen translates the model to a Go program whose main runs the process.",
+			minArgs: 2, maxArgs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&outFile, "o", "", "write the program to `file` instead of stdout")
+			},
+			run: runGen,
+		},
+		{
+			name:  "sim",
+			args:  "file process",
+			short: "simulate a process",
+			long: "Sim runs the process, printing its steps, until it is done, deadlocks or\n" +
+				"has taken the most steps allowed. A simulation recorded with -trace can be\n" +
+				"repeated with -replay.",
+			minArgs: 2, maxArgs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.IntVar(&simSteps, "steps", 1000, "stop a simulation after `n` steps")
+				fs.StringVar(&simSched, "sched", "random", "pick the steps of a simulation with the `scheduler` random, rr or first")
+				fs.Int64Var(&simSeed, "seed", 0, "seed the random scheduler with `n` to repeat a simulation; 0 picks one from the clock")
+				fs.StringVar(&simTrace, "trace", "", "record the steps of a simulation in `file` as JSON lines")
+				fs.StringVar(&simReplay, "replay", "", "replay the simulation recorded in the trace `file`")
+			},
+			run: runSim,
+		},
+		{
+			name:  "explore",
+			args:  "file process",
+			short: "build the labelled transition system of a process",
+			long: "Explore builds the labelled transition system of the process and prints\n" +
+				"its size, or writes it in the Aldebaran or Graphviz format.",
+			minArgs: 2, maxArgs: 2,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&ltsFormat, "format", "summary", "write the explored LTS in `format` summary, aut or dot")
+				fs.StringVar(&outFile, "o", "", "write the explored LTS to `file` instead of stdout")
+				exploreFlags(fs)
+			},
+			run: runExplore,
+		},
+		{
+			name:  "equiv",
+			args:  "file process process",
+			short: "check whether two processes are bisimilar",
+			long: "Equiv explores the two processes as open systems, which talk to their\n" +
+				"environment on every channel not hidden, and tells whether they are\n" +
+				"strongly and weakly bisimilar. If they are not, it prints what tells\n" +
+				"them apart.",
+			minArgs: 3, maxArgs: 3,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&equivKinds, "eq", "both", "check the `equivalence` strong, weak or both")
+				fs.IntVar(&maxStates, "max-states", 100000, "stop an exploration after `n` states")
+				fs.StringVar(&inputs, "inputs", "0,1", "comma-separated `values` the environment sends")
+				fs.StringVar(&hide, "hide", "", "comma-separated `channels` the processes keep to themselves")
+			},
+			run: runEquiv,
+		},
+		{
+			name:  "repl",
+			args:  "[file]",
+			short: "define and simulate processes interactively",
+			long: "Repl reads declarations, expressions and commands from stdin. The\n" +
+				"declarations add to the model, which starts with those of the file if\n" +
+				"one is given; a process term can then be simulated one transition at a\n" +
+				"time. Enter :help in the REPL for its commands. Tozzy run without a\n" +
+				"command starts the REPL.",
+			minArgs: 0, maxArgs: 1,
+			run: runRepl,
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			short:   "print the help of a command",
+			long:    "Help prints the help of the command, or the list of commands.",
+			minArgs: 0, maxArgs: 1,
+			run: runHelp,
+		},
+	}
 }
 
-func readAndEchoStdin() {
-	const waitTime = 10
-	lines := scanForStdin()
+var (
+	verbose    bool   // log the progress of the parser
+	modelName  string // the name of the model in positions, its file
+	simSteps   int    // most steps a simulation takes
+	simSched   string // scheduler of the simulation
+	simSeed    int64  // seed of the random scheduler; 0 for one from the clock
+	simTrace   string // file the simulation trace or counterexample is written to; empty for none
+	simReplay  string // trace the simulation replays; empty for none
+	maxStates  int    // most states an exploration visits
+	open       bool   // explore an open system
+	inputs     string // comma-separated values an open system receives
+	hide       string // comma-separated channels an open system keeps to itself
+	ltsFormat  string // format the LTS is written in
+	outFile    string // file the output is written to; empty for stdout
+	equivKinds string // the equivalences checked
+	propName   string // the property checked; empty for all
+	checkJSON  bool   // print the diagnostics as JSON
+	fmtList    bool   // list the files fmt would change
+	fmtDiff    bool   // print the changes fmt would make
+	fmtWrite   bool   // write back the files fmt changes
+)
+
+// exploreFlags registers the flags of the commands exploring a state space.
+func exploreFlags(fs *flag.FlagSet) {
+	fs.IntVar(&maxStates, "max-states", 100000, "stop an exploration after `n` states")
+	fs.BoolVar(&open, "open", false, "let the explored processes also talk to their environment")
+	fs.StringVar(&inputs, "inputs", "0,1", "comma-separated `values` an open system receives")
+	fs.StringVar(&hide, "hide", "", "comma-separated `channels` an open system keeps to itself")
+}
 
-	for {
-		select {
-		case line := <-lines: // line is []byte
-			fmt.Println(line)
-		case <-time.After(time.Second * time.Duration(waitTime)):
-			// for each line wait for <waitTime> secs
-			fmt.Println(waitTime, "secs expired for one line input... exiting")
-			return
+// run runs the command line args and returns the exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"repl"}
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "tozzy: unknown command %q\nRun 'tozzy help' for usage.\n", args[0])
+		return exitUsage
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&verbose, "v", false, "log the progress of the parser to stderr")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() { cmd.usage(fs, os.Stderr) }
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
 		}
+		return exitUsage
 	}
+	if fs.NArg() < cmd.minArgs || cmd.maxArgs >= 0 && fs.NArg() > cmd.maxArgs {
+		fmt.Fprintf(os.Stderr, "usage: tozzy %s [flags] %s\nRun 'tozzy help %s' for details.\n", cmd.name, cmd.args, cmd.name)
+		return exitUsage
+	}
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	err := cmd.run(fs.Args())
+	switch err := err.(type) {
+	case nil:
+		return exitOK
+	case exitCode:
+		return int(err)
+	case Diagnostics:
+		err.Format(os.Stderr)
+	default:
+		fmt.Fprintf(os.Stderr, "tozzy %s: %s\n", cmd.name, strings.TrimPrefix(err.Error(), cmd.name+": "))
+	}
+	return exitFailure
 }
 
-// get tozzy description from stdin
-func scanForStdin() chan string {
-	lines := make(chan string)
-	go func() {
-		for {
-			scanner := bufio.NewScanner(os.Stdin)
-			scanner.Split(bufio.ScanLines)
-			for scanner.Scan() {
-				lines <- scanner.Text()
-			}
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
-	}()
-	return lines
+	}
+	return nil
+}
+
+// usage writes the list of commands to w.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Tozzy translates process models to Go, simulates them and verifies them.\n\n")
+	fmt.Fprintf(w, "usage: tozzy command [flags] file [process...]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\t%-8s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "\nA file named - is read from stdin. A process may be called with constant\narguments, as in 'P(1,true,\"s\")'. Run 'tozzy help command' for the\nflags of a command. Tozzy exits with 1 when the model has errors or\nfails a check and with 2 when the command line is wrong.\n")
+}
+
+// usage writes the help of the command, with the flags of fs, to w.
+func (cmd *command) usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintf(w, "usage: tozzy %s [flags] %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.long)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "tozzy help: unknown command %q\n", args[0])
+		return exitCode(exitUsage)
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&verbose, "v", false, "log the progress of the parser to stderr")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	cmd.usage(fs, os.Stdout)
+	return nil
+}
+
+// builtins are the functions the expressions of a model may call besides
+// its @@ helpers.
+var builtins = map[string]interface{}{
+	"printf": fmt.Printf,
+}
+
+// parseModel reads and parses the model in the named file, or stdin if
+// the name is -.
+func parseModel(file string) (map[string]*Tree, error) {
+	var text []byte
+	var err error
+	if file == "-" {
+		modelName = "stdin"
+		text, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		modelName = file
+		text, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(modelName, string(text), "%%", "%%", builtins)
+}
+
+// loadModel parses and checks the model in the named file. Only the check
+// command prints the warnings of the checks.
+func loadModel(file string) (map[string]*Tree, error) {
+	treeSet, err := parseModel(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Check(treeSet, modelName); err != nil {
+		return nil, err
+	}
+	return treeSet, nil
 }
 
-func parseTozzyFile() {
-	lines := readLinesFromFile(flag.Arg(0))
-	wholefile := accumulateReceivedBytes(lines)
+// loadInterp loads the model in the named file into an interpreter.
+func loadInterp(file string) (*Interp, error) {
+	treeSet, err := loadModel(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewInterp(treeSet, modelName, builtins)
+}
+
+// output returns the file named by the -o flag, or stdout, and a function
+// closing it.
+func output() (io.Writer, func() error, error) {
+	if outFile == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
 
-	var builtins = map[string]interface{}{
-		"printf": fmt.Printf,
+func runParse(args []string) error {
+	treeSet, err := parseModel(args[0])
+	if err != nil {
+		return err
+	}
+	for _, n := range treeSet[fileKey(modelName)].root.Nodes {
+		fmt.Println(n)
 	}
+	return nil
+}
 
-	_, err2 := Parse("TOZZY", string(wholefile), "%%", "%%", builtins)
-	if err2 != nil {
+func runCheck(args []string) error {
+	var warnings Diagnostics
+	treeSet, err := parseModel(args[0])
+	if err == nil {
+		warnings, err = Check(treeSet, modelName)
+	}
+	if checkJSON {
+		return writeDiagnostics(warnings, err)
 	}
+	warnings.Format(os.Stderr)
+	if err != nil || len(args) == 1 {
+		return err
+	}
+	in, err := NewInterp(treeSet, modelName, builtins)
+	if err != nil {
+		return err
+	}
+	return checkProps(in, args[1])
 }
 
-func readLinesFromFile(fname string) chan []byte {
-	lines := make(chan []byte)
-	go func() {
-		var fin *os.File
+// writeDiagnostics writes the warnings and the diagnostics of err as JSON
+// to stdout. An error err that is not Diagnostics is returned as it is.
+func writeDiagnostics(warnings Diagnostics, err error) error {
+	errs, ok := err.(Diagnostics)
+	if err != nil && !ok {
+		return err
+	}
+	if err := append(warnings, errs...).WriteJSON(os.Stdout); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return exitCode(exitFailure)
+	}
+	return nil
+}
+
+// runFmt formats each file, printing it or, with -l, -d or -w, its name,
+// its changes or itself rewritten.
+func runFmt(args []string) error {
+	for _, file := range args {
+		var src []byte
 		var err error
-		if fin, err = os.Open(fname); err != nil {
-			_, file, line, _ := runtime.Caller(0)
-			log.Fatal("INPUT FILE READ ERROR", file, " ", line+1)
-		}
-		r := bufio.NewReader(fin)
-		
-		for {
-			line, prefix, err := r.ReadLine()
-			if prefix {
-				log.Fatal("prefex: line too long")
-			}
-			if err == io.EOF {
-				fmt.Println(string(line))
-				lines <- line
-				break
-			} else if err != nil {
-				log.Fatal("error occured during ReadLine")
+		if file == "-" {
+			file = "stdin"
+			src, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			src, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		out, err := Format(file, src, builtins)
+		if err != nil {
+			return err
+		}
+		changed := !bytes.Equal(src, out)
+		if fmtList && changed {
+			fmt.Println(file)
+		}
+		if fmtDiff {
+			writeDiff(os.Stdout, file, src, out)
+		}
+		if fmtWrite && changed && file != "stdin" {
+			if err := ioutil.WriteFile(file, out, 0666); err != nil {
+				return err
 			}
-			lines <- line
 		}
-		close(lines)
-	}()
+		if !fmtList && !fmtDiff && !fmtWrite {
+			os.Stdout.Write(out)
+		}
+	}
+	return nil
+}
+
+func runGen(args []string) error {
+	treeSet, err := loadModel(args[0])
+	if err != nil {
+		return err
+	}
+	root, rootArgs, err := parseProc(args[1])
+	if err != nil {
+		return err
+	}
+	src, err := GenGo(treeSet, modelName, root, rootArgs)
+	if err != nil {
+		return err
+	}
+	w, closeOut, err := output()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	if cerr := closeOut(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runSim(args []string) error {
+	in, err := loadInterp(args[0])
+	if err != nil {
+		return err
+	}
+	if simReplay != "" {
+		return replayTrace(in, args[1], simReplay)
+	}
+	return simulate(in, args[1])
+}
+
+func runRepl(args []string) error {
+	return Repl(os.Stdin, os.Stdout, args...)
+}
 
-	return lines
+func runExplore(args []string) error {
+	in, err := loadInterp(args[0])
+	if err != nil {
+		return err
+	}
+	return explore(in, args[1])
 }
 
-func accumulateReceivedBytes(lines <-chan []byte) []byte {
-	wholefile := make([]byte, 0)
+func runEquiv(args []string) error {
+	in, err := loadInterp(args[0])
+	if err != nil {
+		return err
+	}
+	return checkEquiv(in, args[1], args[2])
+}
+
+// simulate runs the simulation of root asked for by the flags.
+func simulate(in *Interp, root string) error {
+	if simSeed == 0 {
+		simSeed = time.Now().UnixNano()
+	}
+	if simSched == "random" {
+		fmt.Printf("simulating with -seed %d\n", simSeed)
+	}
+	sched, err := NewScheduler(simSched, simSeed)
+	if err != nil {
+		return err
+	}
+	name, args, err := parseProc(root)
+	if err != nil {
+		return err
+	}
+	if simTrace == "" {
+		return in.Simulate(os.Stdout, name, args, simSteps, sched, nil)
+	}
+	f, err := os.Create(simTrace)
+	if err != nil {
+		return err
+	}
+	err = in.Simulate(os.Stdout, name, args, simSteps, sched, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// explore builds the state space of root asked for by the flags.
+func explore(in *Interp, root string) error {
+	if open {
+		openSystem(in)
+	}
+	name, args, err := parseProc(root)
+	if err != nil {
+		return err
+	}
+	l, err := in.Explore(name, args, maxStates)
+	if err != nil {
+		return err
+	}
+	if l.Truncated && ltsFormat != "summary" {
+		fmt.Fprintf(os.Stderr, "explore: stopped at %d states; the LTS is incomplete\n", maxStates)
+	}
+	w, closeOut, err := output()
+	if err != nil {
+		return err
+	}
+	switch ltsFormat {
+	case "summary":
+		l.WriteSummary(w)
+	case "aut":
+		err = l.WriteAut(w)
+	case "dot":
+		err = l.WriteDot(w, root)
+	default:
+		err = fmt.Errorf("unknown LTS format %q: want summary, aut or dot", ltsFormat)
+	}
+	if cerr := closeOut(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openSystem opens the system of in to its environment, which sends it
+// the values of the inputs flag, on all channels but those of the hide flag.
+func openSystem(in *Interp) {
+	in.Open = true
+	for _, v := range strings.Split(inputs, ",") {
+		in.Inputs = append(in.Inputs, parseValue(strings.TrimSpace(v)))
+	}
+	in.Hide = make(map[string]bool)
+	for _, ch := range strings.Split(hide, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			in.Hide[ch] = true
+		}
+	}
+}
 
-	for {
-		if line, ok := <-lines; ok { // line is []byte
-			//@@ fmt.Println(line)
-			wholefile = append(wholefile, line...)
-			wholefile = append(wholefile, '\n')
-			//wholefile = append(wholefile, "\n"...) // this works also
+// checkEquiv explores the processes p and q as open systems and tells
+// whether they are bisimilar in the equivalences of the eq flag. It fails
+// if they are not bisimilar in one of them.
+func checkEquiv(in *Interp, p, q string) error {
+	var eqs []Equivalence
+	switch equivKinds {
+	case "strong":
+		eqs = []Equivalence{Strong}
+	case "weak":
+		eqs = []Equivalence{Weak}
+	case "both":
+		eqs = []Equivalence{Strong, Weak}
+	default:
+		return fmt.Errorf("unknown equivalence %q: want strong, weak or both", equivKinds)
+	}
+	procs := []string{p, q}
+	openSystem(in)
+	var ltss [2]*LTS
+	for i, proc := range procs {
+		name, args, err := parseProc(proc)
+		if err != nil {
+			return err
+		}
+		if ltss[i], err = in.Explore(name, args, maxStates); err != nil {
+			return err
+		}
+		if ltss[i].Truncated {
+			return fmt.Errorf("%s has more than %d states", proc, maxStates)
+		}
+	}
+	var err error
+	for _, eq := range eqs {
+		if ok, d := Bisimilar(ltss[0], ltss[1], p, q, eq); ok {
+			fmt.Printf("%s and %s are %s bisimilar\n", p, q, eq)
 		} else {
-			break
+			fmt.Printf("%s and %s are not %s bisimilar: %s\n", p, q, eq, d)
+			err = exitCode(exitFailure)
 		}
 	}
+	return err
+}
 
-	return wholefile
+// checkProps checks the properties of the model asked for by the flags on
+// the state space of root, printing a counterexample for each one that
+// fails. The counterexample of the first failure is written to the trace
+// file, if any. It fails if a property does not hold.
+func checkProps(in *Interp, root string) error {
+	props := in.Props()
+	if propName != "" {
+		var p []*PropDeclNode
+		for _, q := range props {
+			if q.Name == propName {
+				p = append(p, q)
+			}
+		}
+		if p == nil {
+			return fmt.Errorf("no property %q; the model has %s", propName, propNames(props))
+		}
+		props = p
+	}
+	if len(props) == 0 {
+		return fmt.Errorf("the model has no properties")
+	}
+	if open {
+		openSystem(in)
+	}
+	name, args, err := parseProc(root)
+	if err != nil {
+		return err
+	}
+	l, err := in.Explore(name, args, maxStates)
+	if err != nil {
+		return err
+	}
+	var trace io.Writer
+	var failed error
+	for _, p := range props {
+		res, err := in.CheckProp(l, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case res.Holds && res.Complete:
+			fmt.Printf("%s holds\n", p.Name)
+		case res.Holds:
+			fmt.Printf("%s holds on the first %d states; the state bound was reached\n", p.Name, len(l.States))
+		default:
+			fmt.Printf("%s fails:\n", p.Name)
+			failed = exitCode(exitFailure)
+			if simTrace != "" && trace == nil {
+				f, err := os.Create(simTrace)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				trace = f
+				err = in.WriteCounterexample(os.Stdout, f, l, res)
+			} else {
+				err = in.WriteCounterexample(os.Stdout, nil, l, res)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return failed
+}
+
+// parseProc reads a process given on the command line, as in P or P(1,2).
+func parseProc(s string) (name string, args []Value, err error) {
+	i := strings.Index(s, "(")
+	if i < 0 {
+		return s, nil, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("bad process %q: missing )", s)
+	}
+	name = strings.TrimSpace(s[:i])
+	if list := strings.TrimSpace(s[i+1 : len(s)-1]); list != "" {
+		for _, arg := range strings.Split(list, ",") {
+			args = append(args, parseValue(strings.TrimSpace(arg)))
+		}
+	}
+	return name, args, nil
+}
+
+// parseValue reads a value given on the command line: an integer, true,
+// false or else a string.
+func parseValue(s string) Value {
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return n
+	}
+	if b, err := strconv.ParseBool(s); err == nil && (s == "true" || s == "false") {
+		return b
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// replayTrace replays the trace in the named file from root.
+func replayTrace(in *Interp, root, name string) error {
+	proc, args, err := parseProc(root)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	trace, err := ReadTrace(f)
+	if err != nil {
+		return err
+	}
+	return in.Replay(os.Stdout, proc, args, trace)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestMain runs the tests without the trace the parser logs.
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testInterp parses and checks the model src and returns an interpreter
+// for it.
+func testInterp(t *testing.T, src string) *Interp {
+	t.Helper()
+	treeSet, err := Parse("test.tz", src, "%%", "%%", builtins)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := Check(treeSet, "test.tz"); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	in, err := NewInterp(treeSet, "test.tz", builtins)
+	if err != nil {
+		t.Fatalf("interp: %v", err)
+	}
+	return in
+}
+
+// diagnostics returns the warnings and then the errors of checking the
+// model src, each as file:line:col: message.
+func diagnostics(t *testing.T, src string) []string {
+	t.Helper()
+	treeSet, err := Parse("test.tz", src, "%%", "%%", builtins)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	warnings, err := Check(treeSet, "test.tz")
+	ds := warnings
+	if err != nil {
+		ds = append(ds, err.(Diagnostics)...)
+	}
+	var msgs []string
+	for _, d := range ds {
+		msgs = append(msgs, d.Error())
+	}
+	return msgs
+}
+
+// testChecks checks the model of each test and compares the diagnostics.
+func testChecks(t *testing.T, tests []checkTest) {
+	for _, test := range tests {
+		if got := diagnostics(t, test.src); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n\t%q\nwant\n\t%q", test.name, got, test.want)
+		}
+	}
+}
+
+type checkTest struct {
+	name string
+	src  string
+	want []string
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// kindNames are the names of the kinds of actions in a trace.
+var kindNames = [...]string{
+	ActTau:          "sync",
+	ActSend:         "send",
+	ActReceive:      "receive",
+	ActAsyncSend:    "async-send",
+	ActAsyncReceive: "async-receive",
+}
+
+func (k ActionKind) String() string {
+	return kindNames[k]
+}
+
+// A TraceStep is a step of a simulation as recorded in a trace. A trace is
+// written as one JSON object per line, with the fields in the order below.
+type TraceStep struct {
+	Step   int         `json:"step"`
+	Kind   string      `json:"kind"`
+	Chan   string      `json:"chan"`
+	Values []Value     `json:"values"`
+	Procs  []TraceProc `json:"procs"`          // the processes taking part, the sender first
+	Loop   bool        `json:"loop,omitempty"` // in a counterexample, the step starts the cycle
+}
+
+// A TraceProc is a process taking part in a step of a trace.
+type TraceProc struct {
+	Proc string `json:"proc"` // the name of the process
+	At   []int  `json:"at"`   // its position among the parallel components
+	Pos  string `json:"pos"`  // the position of its action in the model
+}
+
+// String formats the step the way a simulation shows it.
+func (ts TraceStep) String() string {
+	var procs []string
+	for _, p := range ts.Procs {
+		procs = append(procs, (&offer{proc: p.Proc, path: p.At}).who())
+	}
+	for k, name := range kindNames {
+		if name == ts.Kind {
+			return fmt.Sprintf("%s: %s", strings.Join(procs, " -> "), Action{ActionKind(k), ts.Chan, ts.Values}.Message())
+		}
+	}
+	return fmt.Sprintf("%s: %s %s%s", strings.Join(procs, " -> "), ts.Kind, ts.Chan, formatValues(ts.Values))
+}
+
+// traceStep records the transition tr taken at step step.
+func (in *Interp) traceStep(step int, tr Transition) TraceStep {
+	ts := TraceStep{
+		Step:   step,
+		Kind:   tr.Action.Kind.String(),
+		Chan:   tr.Action.Chan,
+		Values: tr.Action.Vals,
+	}
+	if ts.Values == nil {
+		ts.Values = []Value{}
+	}
+	for _, o := range tr.offers {
+		pos, _ := in.ev.tree.ErrorContext(o.node)
+		at := o.path
+		if at == nil {
+			at = []int{}
+		}
+		ts.Procs = append(ts.Procs, TraceProc{Proc: o.proc, At: at, Pos: pos})
+	}
+	return ts
+}
+
+// sameStep reports whether the steps a and b take the same action in the
+// same processes. The step numbers and the positions in the model are not
+// compared, so that a trace still replays after the model is edited.
+func sameStep(a, b TraceStep) bool {
+	if a.Kind != b.Kind || a.Chan != b.Chan || len(a.Values) != len(b.Values) || len(a.Procs) != len(b.Procs) {
+		return false
+	}
+	if formatValues(a.Values) != formatValues(b.Values) {
+		return false
+	}
+	for i, p := range a.Procs {
+		q := b.Procs[i]
+		if p.Proc != q.Proc || comparePaths(p.At, q.At) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadTrace reads a trace written by a simulation.
+func ReadTrace(r io.Reader) ([]TraceStep, error) {
+	var trace []TraceStep
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for {
+		var ts TraceStep
+		err := dec.Decode(&ts)
+		if err == io.EOF {
+			return trace, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trace: step %d: %v", len(trace)+1, err)
+		}
+		for i, v := range ts.Values {
+			if ts.Values[i], err = traceValue(v); err != nil {
+				return nil, fmt.Errorf("trace: step %d: %v", len(trace)+1, err)
+			}
+		}
+		trace = append(trace, ts)
+	}
+}
+
+// traceValue converts a value decoded from JSON to a Value.
+func traceValue(v interface{}) (Value, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Int64()
+	case string, bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// A typeVar is a type being inferred. Type variables unified with each
+// other form a class whose root holds the Go type found for all of them.
+type typeVar struct {
+	parent  *typeVar // the next variable towards the root; nil for a root
+	name    string   // on a root, the Go type; empty while unknown
+	untyped bool     // on a root, the type is the default one of a number literal
+	origin  Node     // on a root, what fixed the type; nil for a requirement of the language
+}
+
+// root returns the root of the class of v.
+func (v *typeVar) root() *typeVar {
+	for v.parent != nil {
+		if v.parent.parent != nil {
+			v.parent = v.parent.parent
+		}
+		v = v.parent
+	}
+	return v
+}
+
+// Type returns the Go type of v, int if nothing fixed it.
+func (v *typeVar) Type() string {
+	if r := v.root(); r.name != "" {
+		return r.name
+	}
+	return "int"
+}
+
+// Types are the Go types inferred for the parameters of the processes and
+// the values carried by the channels of a model.
+type Types struct {
+	params map[*ProcDefNode][]*typeVar
+	chans  map[string][]*typeVar
+}
+
+// Param returns the Go type of the parameter i of the process def.
+func (ts *Types) Param(def *ProcDefNode, i int) string {
+	return ts.params[def][i].Type()
+}
+
+// Value returns the Go type of the value i carried by the channel ch.
+func (ts *Types) Value(ch string, i int) string {
+	return ts.chans[ch][i].Type()
+}
+
+// A typer holds the state of InferTypes.
+type typer struct {
+	tree    *Tree
+	types   *Types
+	procs   map[string]*ProcDefNode
+	helpers map[string]*ast.FuncDecl
+	alts    map[*typeVar][]*typeVar // the variables a variable of a merged scope stands for
+	checks  []typeCheck
+	errs    Diagnostics
+}
+
+// A typeCheck is a constraint on a type that unification cannot express,
+// left to be checked once all types are known.
+type typeCheck struct {
+	n    Node
+	v    *typeVar
+	ok   func(name string) bool
+	what string // what the types allowed are
+}
+
+// InferTypes infers the types of the values of the model in file. The
+// types follow from the literals, the operators, the conditions and the
+// signatures of the @@ helpers called; every use of a parameter or channel
+// must agree with every other. A number literal, which must be an integer,
+// takes the numeric type it is used with, int by default, as a constant
+// does in Go. InferTypes
+// expects the model to have passed the arity checks of Check and returns
+// the conflicts it finds as Diagnostics.
+func InferTypes(file *Tree) (*Types, error) {
+	t := &typer{
+		tree:    file,
+		types:   &Types{params: make(map[*ProcDefNode][]*typeVar), chans: make(map[string][]*typeVar)},
+		procs:   make(map[string]*ProcDefNode),
+		helpers: make(map[string]*ast.FuncDecl),
+		alts:    make(map[*typeVar][]*typeVar),
+	}
+	var defs []*ProcDefNode
+	var props []*PropDeclNode
+	for _, n := range file.root.Nodes {
+		switch n := n.(type) {
+		case *GoBlockNode:
+			for _, fn := range n.Funcs {
+				t.helpers[fn.Name.Name] = fn
+			}
+		case *ChanDeclNode:
+			t.slots(n.Name.Ident, len(n.Sizes))
+		case *ProcDefNode:
+			vars := make([]*typeVar, len(n.Params))
+			for i := range vars {
+				vars[i] = &typeVar{}
+			}
+			t.types.params[n] = vars
+			t.procs[n.Name] = n
+			defs = append(defs, n)
+		case *PropDeclNode:
+			props = append(props, n)
+		}
+	}
+	for _, def := range defs {
+		scope := make(map[string]*typeVar)
+		for i, p := range def.Params {
+			scope[p.Ident] = t.types.params[def][i]
+		}
+		t.proc(def.Body, scope)
+	}
+	for _, p := range props {
+		Inspect(p.Formula, func(n Node) bool {
+			if a, ok := n.(*ActionPatternNode); ok && a.Op != "" {
+				slots := t.types.chans[a.Chan.Ident]
+				for i, arg := range a.Args {
+					if id, ok := arg.(*IdentifierNode); (!ok || id.Ident != "_") && i < len(slots) {
+						t.unify(arg, t.expr(arg, nil), slots[i], t.slotName(a.Chan.Ident, i))
+					}
+				}
+			}
+			return true
+		})
+	}
+	for _, c := range t.checks {
+		if r := c.v.root(); r.name != "" && !c.ok(r.name) {
+			t.errorf(c.n, "%s is %s but must be %s", c.n, article(r.name), c.what)
+		}
+	}
+	if len(t.errs) > 0 {
+		t.errs.sort()
+		return nil, t.errs
+	}
+	return t.types, nil
+}
+
+// errorf records an error at the node and returns it.
+func (t *typer) errorf(n Node, format string, args ...interface{}) *Diagnostic {
+	d := t.tree.diagnostic(SeverityError, n, format, args...)
+	t.errs = append(t.errs, d)
+	return d
+}
+
+// slots returns the type variables of the values carried by the channel
+// ch, making them the first time. An action carrying another number of
+// values than the first, which Check only warns of on an undeclared
+// channel, gets variables of its own.
+func (t *typer) slots(ch string, arity int) []*typeVar {
+	if vars, ok := t.types.chans[ch]; ok && len(vars) == arity {
+		return vars
+	}
+	vars := make([]*typeVar, arity)
+	for i := range vars {
+		vars[i] = &typeVar{}
+	}
+	if _, ok := t.types.chans[ch]; !ok {
+		t.types.chans[ch] = vars
+	}
+	return vars
+}
+
+func (t *typer) slotName(ch string, i int) string {
+	if len(t.types.chans[ch]) == 1 {
+		return "the value of channel " + ch
+	}
+	return fmt.Sprintf("value %d of channel %s", i+1, ch)
+}
+
+// unify unifies the type got of the node n with the type want of what n
+// is used as, described by what.
+func (t *typer) unify(n Node, got, want *typeVar, what string) {
+	g, w := got.root(), want.root()
+	switch {
+	case g == w:
+		return
+	case g.name == "":
+		g.parent = w
+		return
+	case w.name == "":
+		w.parent = g
+		return
+	case g.name == w.name:
+		g.parent = w
+		w.untyped = w.untyped && g.untyped
+		return
+	case g.untyped && convertible(g.name, w.name):
+		g.parent = w
+		return
+	case w.untyped && convertible(w.name, g.name):
+		w.parent = g
+		return
+	}
+	if w.origin == nil {
+		t.errorf(n, "%s is %s but %s must be %s", n, article(g.name), what, article(w.name))
+		return
+	}
+	t.errorf(n, "%s is %s but %s is %s", n, article(g.name), what, article(w.name)).
+		note(t.tree, w.origin, "the type of %s comes from here", what)
+}
+
+// convertible reports whether a number literal of the default type from
+// can have the type to.
+func convertible(from, to string) bool {
+	switch to {
+	case "float32", "float64":
+		return true
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune":
+		return from == "int"
+	}
+	return false
+}
+
+// article returns the type name preceded by an indefinite article.
+func article(name string) string {
+	switch name[0] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return "an " + name
+	}
+	return "a " + name
+}
+
+func isInteger(name string) bool {
+	return convertible("int", name) && !convertible("float64", name)
+}
+
+func isNumeric(name string) bool {
+	return convertible("int", name) || convertible("float64", name)
+}
+
+func isOrdered(name string) bool {
+	return isNumeric(name) || name == "string"
+}
+
+// proc infers the types in the process n, in the scope of the variables
+// bound so far, and returns the scope of what runs after it. Each process
+// is visited once: what follows a choice or an if is typed in the scope
+// merged from its branches.
+func (t *typer) proc(n Node, scope map[string]*typeVar) map[string]*typeVar {
+	switch n := n.(type) {
+	case *SendNode:
+		t.send(n.Chan.Ident, n.Args, scope)
+	case *AsyncSendNode:
+		t.send(n.Chan.Ident, n.Args, scope)
+	case *ReceiveNode:
+		return t.receive(n.Chan.Ident, n.Vars, scope)
+	case *AsyncReceiveNode:
+		return t.receive(n.Chan.Ident, n.Vars, scope)
+	case *PrefixNode:
+		return t.proc(n.Right, t.proc(n.Left, scope))
+	case *ChoiceNode:
+		exits := make([]map[string]*typeVar, len(n.Branches))
+		for i, branch := range n.Branches {
+			exits[i] = t.proc(branch, scope)
+		}
+		return t.merge(exits)
+	case *IfNode:
+		t.unify(n.Cond, t.expr(n.Cond, scope), &typeVar{name: "bool"}, "a condition")
+		exits := []map[string]*typeVar{t.proc(n.List, scope), scope}
+		if n.ElseList != nil {
+			exits[1] = t.proc(n.ElseList, scope)
+		}
+		return t.merge(exits)
+	case *ListNode:
+		for _, elem := range n.Nodes {
+			scope = t.proc(elem, scope)
+		}
+	case *ProcCallNode:
+		t.call(n, scope)
+	case *ParallelNode:
+		for _, c := range n.Procs {
+			t.call(c, scope)
+		}
+	}
+	return scope
+}
+
+// merge returns the scope after the branches whose scopes are exits. A
+// variable bound in every branch stays in scope; if they bind it to
+// different variables, it stands for all of them, which get one type where
+// it is used.
+func (t *typer) merge(exits []map[string]*typeVar) map[string]*typeVar {
+	if len(exits) == 1 {
+		return exits[0]
+	}
+	merged := make(map[string]*typeVar, len(exits[0]))
+	for name, v := range exits[0] {
+		vars := []*typeVar{v}
+		for _, exit := range exits[1:] {
+			w, ok := exit[name]
+			if !ok {
+				vars = nil
+				break
+			}
+			if w != v {
+				vars = append(vars, w)
+			}
+		}
+		switch len(vars) {
+		case 0:
+		case 1:
+			merged[name] = v
+		default:
+			m := &typeVar{}
+			t.alts[m] = vars
+			merged[name] = m
+		}
+	}
+	return merged
+}
+
+// use returns the variable v of the identifier n, unifying the variables
+// it stands for, if any, the first time it is used.
+func (t *typer) use(n *IdentifierNode, v *typeVar) *typeVar {
+	alts := t.alts[v]
+	delete(t.alts, v)
+	for _, alt := range alts {
+		t.unify(n, t.use(n, alt), v, fmt.Sprintf("%s as received in another branch", n))
+	}
+	return v
+}
+
+func (t *typer) send(ch string, args []Node, scope map[string]*typeVar) {
+	slots := t.slots(ch, len(args))
+	for i, arg := range args {
+		t.unify(arg, t.expr(arg, scope), slots[i], t.slotName(ch, i))
+	}
+}
+
+// receive returns scope with the variables vars bound to the values
+// received on the channel ch.
+func (t *typer) receive(ch string, vars []*IdentifierNode, scope map[string]*typeVar) map[string]*typeVar {
+	slots := t.slots(ch, len(vars))
+	inner := make(map[string]*typeVar, len(scope)+len(vars))
+	for name, v := range scope {
+		inner[name] = v
+	}
+	for i, v := range vars {
+		inner[v.Ident] = slots[i]
+	}
+	return inner
+}
+
+func (t *typer) call(n *ProcCallNode, scope map[string]*typeVar) {
+	def := t.procs[n.Name]
+	if def == nil {
+		return
+	}
+	for i, arg := range n.Args {
+		if i < len(def.Params) {
+			t.unify(arg, t.expr(arg, scope), t.types.params[def][i], fmt.Sprintf("parameter %s of process %s", def.Params[i], def.Name))
+		}
+	}
+}
+
+// expr returns the type of the expression n.
+func (t *typer) expr(n Node, scope map[string]*typeVar) *typeVar {
+	switch n := n.(type) {
+	case *IdentifierNode:
+		if v, ok := scope[n.Ident]; ok {
+			return t.use(n, v)
+		}
+		return &typeVar{}
+	case *NumberNode:
+		if !n.IsInt {
+			// The interpreter computes with integers only.
+			t.errorf(n, "%s is not an integer", n.Text)
+			return &typeVar{}
+		}
+		return &typeVar{name: "int", untyped: true, origin: n}
+	case *StringNode:
+		return &typeVar{name: "string", origin: n}
+	case *BoolNode:
+		return &typeVar{name: "bool", origin: n}
+	case *UnaryExprNode:
+		x := t.expr(n.X, scope)
+		if n.Op == "!" {
+			t.unify(n.X, x, &typeVar{name: "bool"}, "the operand of !")
+			return x
+		}
+		t.checks = append(t.checks, typeCheck{n.X, x, isNumeric, "a number"})
+		return x
+	case *BinaryExprNode:
+		x, y := t.expr(n.X, scope), t.expr(n.Y, scope)
+		switch n.Op {
+		case "&&", "||":
+			t.unify(n.X, x, &typeVar{name: "bool"}, "an operand of "+n.Op)
+			t.unify(n.Y, y, &typeVar{name: "bool"}, "an operand of "+n.Op)
+			return x
+		}
+		t.unify(n.Y, y, x, fmt.Sprintf("the left operand of %s, %s,", n.Op, n.X))
+		switch n.Op {
+		case "==", "!=":
+			return &typeVar{name: "bool", origin: n}
+		case "<", "<=", ">", ">=":
+			t.checks = append(t.checks, typeCheck{n.X, x, isOrdered, "a number or a string"})
+			return &typeVar{name: "bool", origin: n}
+		case "+":
+			t.checks = append(t.checks, typeCheck{n.X, x, isOrdered, "a number or a string"})
+		case "%":
+			t.checks = append(t.checks, typeCheck{n.X, x, isInteger, "an integer"})
+		default:
+			t.checks = append(t.checks, typeCheck{n.X, x, isNumeric, "a number"})
+		}
+		return x
+	case *CallExprNode:
+		return t.callHelper(n, scope)
+	}
+	return &typeVar{}
+}
+
+// callHelper returns the type of a call of a @@ helper. Calls of builtins
+// leave the types of their arguments and result open.
+func (t *typer) callHelper(n *CallExprNode, scope map[string]*typeVar) *typeVar {
+	var args []*typeVar
+	for _, arg := range n.Args {
+		args = append(args, t.expr(arg, scope))
+	}
+	fn := t.helpers[n.Fun.Ident]
+	if fn == nil {
+		return &typeVar{}
+	}
+	var params []string
+	var variadic bool
+	for _, field := range fn.Type.Params.List {
+		typ := field.Type
+		if ell, ok := typ.(*ast.Ellipsis); ok {
+			typ, variadic = ell.Elt, true
+		}
+		names := []string{""}
+		if len(field.Names) > 0 {
+			names = names[:0]
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+		}
+		for range names {
+			params = append(params, types.ExprString(typ))
+		}
+	}
+	if len(args) != len(params) && !(variadic && len(args) >= len(params)-1) {
+		t.errorf(n, "wrong number of arguments to %s: %d, want %d", n.Fun, len(args), len(params))
+		return &typeVar{}
+	}
+	for i, arg := range args {
+		param := params[len(params)-1]
+		if i < len(params) {
+			param = params[i]
+		}
+		t.unify(n.Args[i], arg, &typeVar{name: param}, fmt.Sprintf("argument %d of %s", i+1, n.Fun))
+	}
+	results := fn.Type.Results
+	if results == nil || results.NumFields() != 1 {
+		t.errorf(n, "%s does not return a single value", n.Fun)
+		return &typeVar{}
+	}
+	return &typeVar{name: types.ExprString(results.List[0].Type), origin: n}
+}
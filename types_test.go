@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInferTypes(t *testing.T) {
+	testChecks(t, []checkTest{
+		{"branches", "%%\nP = (a?x + b?x).c!(x+1).<P>\nQ = a!1.<Q> + b!2.<Q>\nR = c?y.d!(y>0).<R>\nT = d?_.<T>\n%%\n", nil},
+		{"conflicting branches", "%%\nP = (a?x + b?x).c!(x+1).<P>\nQ = a!1.<Q> + b!true.<Q>\nR = c?_.<R>\n%%\n", []string{
+			"test.tz:3:17: true is a bool but the value of channel b is an int",
+		}},
+		{"condition", "%%\nP(x) = if x+1 { a!x }\n%%\n", []string{
+			"test.tz:2:11: x+1 is an int but a condition must be a bool",
+		}},
+		{"remainder", "@@\nfunc half(x int) float64 { return float64(x) / 2 }\n@@\n%%\nP(x) = a!(x % 2).b!(half(x) % 2).<P(x)>\n%%\n", []string{
+			"test.tz:5:21: half(x) is a float64 but must be an integer",
+		}},
+		{"literal", "%%\nP(x) = a!(x * 1.5).<P(x)>\n%%\n", []string{
+			"test.tz:2:15: 1.5 is not an integer",
+		}},
+		// Each choice is typed once, not once for each way to reach it.
+		{"chain", "%%\nP(x) = " + strings.Repeat("(a!x + b?_).", 40) + "<P(x)>\nQ = a?_.<Q> + b!1.<Q>\n%%\n", nil},
+	})
+}
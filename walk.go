@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Inspect traverses the tree rooted at n in depth-first order: it calls f(n);
+// if that returns true, Inspect visits each of the children of n in lexical
+// order, followed by a call of f(nil).
+func Inspect(n Node, f func(Node) bool) {
+	if n == nil || !f(n) {
+		return
+	}
+	for _, c := range children(n) {
+		Inspect(c, f)
+	}
+	f(nil)
+}
+
+// children returns the direct children of n in lexical order.
+func children(n Node) []Node {
+	switch n := n.(type) {
+	case *ListNode:
+		return n.Nodes
+	case *ProcDefNode:
+		return append(identNodes(n.Params), n.Body)
+	case *SendNode:
+		return append([]Node{n.Chan}, n.Args...)
+	case *AsyncSendNode:
+		return append([]Node{n.Chan}, n.Args...)
+	case *ReceiveNode:
+		return append([]Node{n.Chan}, identNodes(n.Vars)...)
+	case *AsyncReceiveNode:
+		return append([]Node{n.Chan}, identNodes(n.Vars)...)
+	case *PrefixNode:
+		return []Node{n.Left, n.Right}
+	case *ChoiceNode:
+		return n.Branches
+	case *ProcCallNode:
+		return n.Args
+	case *ParallelNode:
+		nodes := make([]Node, len(n.Procs))
+		for i, c := range n.Procs {
+			nodes[i] = c
+		}
+		return nodes
+	case *IfNode:
+		nodes := []Node{n.Cond, n.List}
+		if n.ElseList != nil {
+			nodes = append(nodes, n.ElseList)
+		}
+		return nodes
+	case *ChanDeclNode:
+		nodes := []Node{n.Name}
+		for _, size := range n.Sizes {
+			nodes = append(nodes, size)
+		}
+		return nodes
+	case *PropDeclNode:
+		return []Node{n.Formula}
+	case *TemporalNode:
+		if n.Y == nil {
+			return []Node{n.X}
+		}
+		return []Node{n.X, n.Y}
+	case *ActionPatternNode:
+		return append([]Node{n.Chan}, n.Args...)
+	case *BinaryExprNode:
+		return []Node{n.X, n.Y}
+	case *UnaryExprNode:
+		return []Node{n.X}
+	case *CallExprNode:
+		return append([]Node{n.Fun}, n.Args...)
+	case *IdentifierNode, *NumberNode, *StringNode, *BoolNode, *NilNode, *CommentNode, *GoBlockNode:
+		return nil
+	}
+	panic(fmt.Sprintf("children: unexpected node type %T", n))
+}